@@ -0,0 +1,9 @@
+//go:build !unix
+
+package backup
+
+// Reports whether the current process may write to path.
+// Non-unix platforms have no cheap check, so the online backup is assumed to work.
+func isWritable(path string) bool {
+	return true
+}
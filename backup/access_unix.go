@@ -0,0 +1,10 @@
+//go:build unix
+
+package backup
+
+import "golang.org/x/sys/unix"
+
+// Reports whether the current process may write to path.
+func isWritable(path string) bool {
+	return unix.Access(path, unix.W_OK) == nil
+}
@@ -9,19 +9,32 @@ import (
 	"golang.org/x/term"
 )
 
+// Prompts for a new passphrase twice. When stdin is not a terminal, such
+// as when archives or passphrases are piped in, the controlling terminal
+// is used instead.
 func promptForPassphrase() (string, error) {
-	fd := int(os.Stdin.Fd())
+	in, out := os.Stdin, os.Stdout
+	if !term.IsTerminal(int(in.Fd())) {
+		tty, err := openTTY()
+		if err != nil {
+			return "", fmt.Errorf("no passphrase given and no terminal to prompt on, " +
+				"use --age-passphrase, --age-passphrase-command or --passphrase-stdin")
+		}
+		defer tty.Close()
+		in, out = tty, tty
+	}
+	fd := int(in.Fd())
 
-	fmt.Print("Set encryption passphrase: ")
+	fmt.Fprint(out, "Set encryption passphrase: ")
 	bytePassphrase, err := term.ReadPassword(fd)
-	fmt.Println()
+	fmt.Fprintln(out)
 	if err != nil {
 		return "", fmt.Errorf("error reading password: %s", err)
 	}
 
-	fmt.Print("Confirm encryption passphrase: ")
+	fmt.Fprint(out, "Confirm encryption passphrase: ")
 	bytePassphraseConfirmation, err := term.ReadPassword(fd)
-	fmt.Println()
+	fmt.Fprintln(out)
 	if err != nil {
 		return "", fmt.Errorf("error reading password: %s", err)
 	}
@@ -33,15 +46,65 @@ func promptForPassphrase() (string, error) {
 	return string(bytePassphrase), nil
 }
 
-func encryptWithPassphrase(data []byte, passphrase string) ([]byte, error) {
+// Returns the recipients to encrypt archives to: those of the key file,
+// or the passphrase, prompting for one when neither is set.
+func encryptionRecipients(cfg Config) ([]age.Recipient, error) {
+	if cfg.AgeKeyFile != "" {
+		return KeyFileRecipients(cfg.AgeKeyFile)
+	}
+
+	passphrase := cfg.AgePassphrase
+	if passphrase == "" {
+		var err error
+		if passphrase, err = promptForPassphrase(); err != nil {
+			return nil, err
+		}
+	}
 	recipient, err := age.NewScryptRecipient(passphrase)
 	if err != nil {
 		return nil, err
 	}
+	return []age.Recipient{recipient}, nil
+}
+
+// KeyFileRecipients returns the recipients archives are encrypted to with
+// the age key file at path. The file holds identities, as written by
+// age-keygen, whose public keys are used, or only recipients, so that the
+// host running backups cannot decrypt them.
+func KeyFileRecipients(path string) ([]age.Recipient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading age key file: %w", err)
+	}
+
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		recipients, rerr := age.ParseRecipients(bytes.NewReader(data))
+		if rerr != nil {
+			return nil, fmt.Errorf("age key file %s holds no age identities or recipients: %w", path, err)
+		}
+		return recipients, nil
+	}
+
+	recipients := make([]age.Recipient, 0, len(identities))
+	for _, id := range identities {
+		switch id := id.(type) {
+		case *age.X25519Identity:
+			recipients = append(recipients, id.Recipient())
+		case *age.HybridIdentity:
+			recipients = append(recipients, id.Recipient())
+		default:
+			return nil, fmt.Errorf("age key file %s: unsupported identity type %T", path, id)
+		}
+	}
+	return recipients, nil
+}
 
+// Encrypts data to the recipients and zeroes the plaintext.
+func encrypt(data []byte, recipients ...age.Recipient) ([]byte, error) {
 	buf := &bytes.Buffer{}
 
-	w, err := age.Encrypt(buf, recipient)
+	w, err := age.Encrypt(buf, recipients...)
 	if err != nil {
 		return nil, err
 	}
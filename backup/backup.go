@@ -3,12 +3,22 @@ package backup
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
+
+	"github.com/mijolabs/vaultage/destination"
+	"github.com/mijolabs/vaultage/history"
+	"github.com/mijolabs/vaultage/logging"
+	"github.com/mijolabs/vaultage/spool"
 )
 
 // Config holds the configuration needed for performing backups.
@@ -20,6 +30,36 @@ type Config struct {
 	WithoutEncryption  bool
 	AgePassphrase      string
 	AgeKeyFile         string
+	Snapshot           SnapshotStrategy
+	// Targets receive the finished archive. When empty, archives are
+	// written to OutputDir on the local filesystem.
+	Targets []Target
+	// Spool, if set, queues archives for targets that could not be reached.
+	Spool *spool.Spool
+	// StateDir holds vaultage's own state, such as the spool.
+	StateDir string
+	// History, if set, receives a record of every run.
+	History *history.Journal
+	// Trigger names what started the run, for the history.
+	Trigger history.Trigger
+	// Instance names the Vaultwarden instance when several are configured.
+	Instance string
+	// FilenameTemplate names the archives. When nil, the default
+	// template is used.
+	FilenameTemplate *FilenameTemplate
+}
+
+// OutputTargets returns the targets archives are written to, each
+// recognizing archives by cfg.FilenameTemplate.
+func (cfg Config) OutputTargets() []Target {
+	targets := []Target{{Destination: destination.NewLocal(cfg.OutputDir)}}
+	if len(cfg.Targets) > 0 {
+		targets = slices.Clone(cfg.Targets)
+	}
+	for i := range targets {
+		targets[i].Names = cfg.FilenameTemplate
+	}
+	return targets
 }
 
 const (
@@ -31,67 +71,180 @@ const (
 	configFileName = "config.json"
 )
 
-func Perform(ctx context.Context, cfg Config) error {
-	// Ensure output directory exists
-	outputDir := cfg.OutputDir
-	if outputDir == "" {
-		outputDir = "."
-	}
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("creating output directory: %w", err)
-	}
+// Perform snapshots the Vaultwarden data, archives and optionally encrypts
+// it, and uploads the archive to all targets concurrently. The returned
+// error is non-nil if any target failed, the Result reports every target.
+// Errors are wrapped in a StageError naming the step that failed. Every
+// run is recorded in cfg.History, if set.
+func Perform(ctx context.Context, cfg Config) (result Result, err error) {
+	result.Started = time.Now()
+	result.Instance = cfg.Instance
+	result.RunID = logging.NewRunID()
+	ctx = logging.With(ctx, "run_id", result.RunID)
+	slog.InfoContext(ctx, "backup started", "data_dir", cfg.DataDir, "trigger", cfg.Trigger)
+
+	defer func() {
+		result.Duration = time.Since(result.Started)
+		if err != nil {
+			stage, _ := ErrorStage(err)
+			slog.ErrorContext(ctx, "backup failed", "stage", stage, "duration", result.Duration, logging.Err(err))
+		} else {
+			slog.InfoContext(ctx, "backup finished",
+				"archive", result.Filename, "bytes", result.Size, "duration", result.Duration)
+		}
+
+		if cfg.History != nil {
+			record := historyRecord(cfg.Trigger, result, err)
+			if err := cfg.History.Append(record); err != nil {
+				slog.ErrorContext(ctx, "recording backup history failed", "path", cfg.History.Path(), logging.Err(err))
+			}
+		}
+	}()
 
 	// Gather in-memory db bytes and any on-disk files
-	archiveEntries, err := getArchiveEntries(cfg)
+	archiveEntries, err := getArchiveEntries(ctx, cfg)
 	if err != nil {
-		return err
+		return result, &StageError{Stage: StageSnapshot, Err: err}
+	}
+	result.DatabaseSize = int64(len(archiveEntries[0].Data))
+	result.DatabaseSHA256 = sha256Hex(archiveEntries[0].Data)
+	for _, entry := range archiveEntries {
+		if entry.Name == attachmentsDirName {
+			result.Attachments = countFiles(entry.Path)
+		}
 	}
-
-	// Generate output filename
-	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("vaultage-%s.tar", timestamp)
-	outFilePath := filepath.Join(outputDir, filename)
 
 	// Create in-memory tar archive
 	archiveBuf := &bytes.Buffer{}
 	if err := CreateArchive(archiveBuf, archiveEntries); err != nil {
-		return fmt.Errorf("creating archive: %w", err)
+		return result, &StageError{Stage: StageArchive, Err: fmt.Errorf("creating archive: %w", err)}
 	}
 	archiveBytes := archiveBuf.Bytes()
 
-	if cfg.WithoutEncryption {
-		log.Printf("writing unencrypted backup: %s (%s)", outFilePath, formatSize(int64(len(archiveBytes))))
-		return writeBytesToDisk(outFilePath, archiveBytes)
+	slog.DebugContext(ctx, "archive created", "stage", StageArchive, "bytes", len(archiveBytes),
+		"database_bytes", result.DatabaseSize, "attachments", result.Attachments)
+
+	// Generate output filename
+	ext := archiveExt
+	if !cfg.WithoutEncryption {
+		ext += encryptedExt
+	}
+	names := cmp.Or(cfg.FilenameTemplate, defaultFilenameTemplate)
+	nameData := newNameData(result.Started, hostname(), cfg.Instance, result.RunID, string(cfg.Trigger))
+	basename, err := names.render(nameData)
+	if err != nil {
+		return result, &StageError{Stage: StageArchive, Err: fmt.Errorf("naming archive: %w", err)}
 	}
+	filename := basename + ext
 
-	outEncryptedFilePath := outFilePath + ".age"
-	log.Printf("creating encrypted backup: %s", outEncryptedFilePath)
-	passphrase := cfg.AgePassphrase
-	if passphrase == "" {
-		passphrase, err = promptForPassphrase()
+	data := archiveBytes
+	if cfg.WithoutEncryption {
+		slog.WarnContext(ctx, "writing unencrypted backup", "archive", filename)
+	} else {
+		recipients, err := encryptionRecipients(cfg)
+		if err != nil {
+			return result, &StageError{Stage: StageEncrypt, Err: err}
+		}
+
+		data, err = encrypt(archiveBytes, recipients...)
 		if err != nil {
-			return err
+			return result, &StageError{Stage: StageEncrypt, Err: err}
+		}
+		slog.DebugContext(ctx, "archive encrypted", "stage", StageEncrypt, "archive", filename, "bytes", len(data))
+	}
+
+	result.Filename = filename
+	result.Size = int64(len(data))
+	result.SHA256 = sha256Hex(data)
+	result.Destinations = distribute(ctx, cfg.OutputTargets(), filename, data)
+	for _, d := range result.Destinations {
+		if d.Err == nil {
+			result.Filename = d.Filename
+			break
 		}
 	}
 
-	encryptedArchiveBytes, err := encryptWithPassphrase(archiveBytes, passphrase)
+	if cfg.Spool != nil {
+		spoolFailed(ctx, cfg.Spool, result.Destinations, data)
+	}
+
+	return result, result.Err()
+}
+
+// Returns the history record describing a finished run.
+func historyRecord(trigger history.Trigger, result Result, err error) history.Record {
+	r := history.Record{
+		RunID:    result.RunID,
+		Instance: result.Instance,
+		Trigger:  trigger,
+		Started:  result.Started,
+		Finished: result.Started.Add(result.Duration),
+		Result:   history.ResultSuccess,
+		Archive:  result.Filename,
+		Size:     result.Size,
+		SHA256:   result.SHA256,
+
+		DatabaseSHA256: result.DatabaseSHA256,
+	}
 	if err != nil {
-		return err
+		stage, _ := ErrorStage(err)
+		r.Result = history.ResultFailure
+		r.Stage = string(stage)
+		r.Error = err.Error()
+	}
+	for _, d := range result.Destinations {
+		dest := history.Destination{Name: d.Destination, Spooled: d.Spooled, Pruned: d.Pruned}
+		if d.Filename != result.Filename {
+			dest.Archive = d.Filename
+		}
+		if d.Err != nil {
+			dest.Error = d.Err.Error()
+		} else if d.PruneErr != nil {
+			dest.Error = "pruning: " + d.PruneErr.Error()
+		}
+		r.Destinations = append(r.Destinations, dest)
 	}
+	return r
+}
 
-	log.Printf("writing encrypted backup: %s (%s)", outEncryptedFilePath, formatSize(int64(len(archiveBytes))))
-	return writeBytesToDisk(outEncryptedFilePath, encryptedArchiveBytes)
+// DatabaseDigest snapshots the database in cfg.DataDir and returns the
+// digest of the snapshot, to compare against Result.DatabaseSHA256 of an
+// earlier run. Snapshots of unchanged data have the same digest, however
+// often the database files were touched.
+func DatabaseDigest(ctx context.Context, cfg Config) (string, error) {
+	data, err := SnapshotDatabase(ctx, filepath.Join(cfg.DataDir, dbFileName), cfg.Snapshot)
+	if err != nil {
+		return "", fmt.Errorf("backing up database: %w", err)
+	}
+	return sha256Hex(data), nil
 }
 
-func getArchiveEntries(cfg Config) ([]ArchiveEntry, error) {
-	log.Printf("enumerating archive entries...")
+// Returns the hex encoded SHA-256 digest of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
 
+// Returns the number of regular files below dir.
+func countFiles(dir string) int {
+	n := 0
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func getArchiveEntries(ctx context.Context, cfg Config) ([]ArchiveEntry, error) {
 	// Backup SQLite database to memory
 	dbPath := filepath.Join(cfg.DataDir, dbFileName)
-	dbData, err := BackupToMemory(dbPath)
+	dbData, err := SnapshotDatabase(ctx, dbPath, cfg.Snapshot)
 	if err != nil {
 		return nil, fmt.Errorf("backing up database: %w", err)
 	}
+	slog.DebugContext(ctx, "database snapshot taken", "stage", StageSnapshot, "path", dbPath, "bytes", len(dbData))
 
 	// Collect files to archive
 	archiveEntries := []ArchiveEntry{
@@ -132,40 +285,3 @@ func getArchiveEntries(cfg Config) ([]ArchiveEntry, error) {
 
 	return archiveEntries, nil
 }
-
-func writeBytesToDisk(outputFilePath string, data []byte) error {
-	if err := os.WriteFile(outputFilePath, data, 0644); err != nil {
-		// Clean up partial file on error
-		os.Remove(outputFilePath)
-		return fmt.Errorf("writing backup file: %w", err)
-	}
-
-	info, err := os.Stat(outputFilePath)
-	if err != nil {
-		return fmt.Errorf("getting file info: %w", err)
-	}
-
-	log.Printf("write successful: %s (%s)", outputFilePath, formatSize(info.Size()))
-
-	return nil
-}
-
-// formatSize returns a human-readable file size string.
-func formatSize(bytes int64) string {
-	const (
-		KB = 1024
-		MB = KB * 1024
-		GB = MB * 1024
-	)
-
-	switch {
-	case bytes >= GB:
-		return fmt.Sprintf("%.1f GB", float64(bytes)/GB)
-	case bytes >= MB:
-		return fmt.Sprintf("%.1f MB", float64(bytes)/MB)
-	case bytes >= KB:
-		return fmt.Sprintf("%.1f KB", float64(bytes)/KB)
-	default:
-		return fmt.Sprintf("%d B", bytes)
-	}
-}
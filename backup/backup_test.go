@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/mijolabs/vaultage/destination"
+	"github.com/mijolabs/vaultage/history"
+)
+
+func TestPerform_RecordsHistory(t *testing.T) {
+	dataDir := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(dataDir, dbFileName))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("creating test data: %v", err)
+	}
+	db.Close()
+
+	journal := history.Open(t.TempDir())
+
+	local := destination.NewLocal(t.TempDir())
+	cfg := Config{
+		DataDir:           dataDir,
+		WithoutEncryption: true,
+		Snapshot:          SnapshotOnline,
+		Targets:           []Target{{Destination: local}, {Destination: brokenDestination{}}},
+		History:           journal,
+		Trigger:           history.TriggerManual,
+	}
+	result, err := Perform(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("expected error for broken destination")
+	}
+
+	records, err := journal.Records()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected one history record, got %+v, %v", records, err)
+	}
+	r := records[0]
+	if r.RunID != result.RunID || r.Trigger != history.TriggerManual || r.Result != history.ResultFailure || r.Stage != string(StageUpload) {
+		t.Fatalf("unexpected record: %+v", r)
+	}
+	if len(r.Destinations) != 2 || r.Destinations[0].Error != "" || r.Destinations[1].Error == "" {
+		t.Fatalf("unexpected destinations: %+v", r.Destinations)
+	}
+
+	data, err := os.ReadFile(filepath.Join(local.Dir(), r.Archive))
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if r.SHA256 != hex.EncodeToString(sum[:]) || r.Size != int64(len(data)) {
+		t.Fatalf("archive digest or size not recorded: %+v", r)
+	}
+
+	// The database digest matches a later snapshot of the unchanged data
+	digest, err := DatabaseDigest(context.Background(), cfg)
+	if err != nil || r.DatabaseSHA256 != digest {
+		t.Fatalf("database digest = %q, want %q, %v", r.DatabaseSHA256, digest, err)
+	}
+}
+
+func TestPerform_EncryptsToKeyFile(t *testing.T) {
+	dataDir := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(dataDir, dbFileName))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatalf("creating test data: %v", err)
+	}
+	db.Close()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generating identity: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(keyFile, []byte(identity.String()+"\n"), 0o600); err != nil {
+		t.Fatalf("writing key file: %v", err)
+	}
+
+	local := destination.NewLocal(t.TempDir())
+	result, err := Perform(context.Background(), Config{
+		DataDir:    dataDir,
+		AgeKeyFile: keyFile,
+		Snapshot:   SnapshotOnline,
+		Targets:    []Target{{Destination: local}},
+	})
+	if err != nil {
+		t.Fatalf("Perform: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(local.Dir(), result.Filename))
+	if err != nil {
+		t.Fatalf("opening archive: %v", err)
+	}
+	defer f.Close()
+	rd, err := age.Decrypt(f, identity)
+	if err != nil {
+		t.Fatalf("decrypting archive with the key file identity: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, rd); err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mijolabs/vaultage/destination"
+	"github.com/mijolabs/vaultage/logging"
+	"github.com/mijolabs/vaultage/retention"
+)
+
+// Target is a destination together with the retention policy applied to it.
+type Target struct {
+	Destination destination.Destination
+	Retention   retention.Policy
+	// Names recognizes the archives in Destination. When nil, only
+	// archives with the default names are recognized.
+	Names *FilenameTemplate
+}
+
+// DestinationResult reports what happened at one target.
+type DestinationResult struct {
+	// Destination describes the target, see destination.Destination.String.
+	Destination string
+	// Err is set when the archive could not be stored.
+	Err error
+	// Pruned lists the archives removed by the retention policy.
+	Pruned []string
+	// PruneErr is set when applying the retention policy failed.
+	// The archive itself was still stored.
+	PruneErr error
+	// Spooled is set when the failed upload was queued in the spool
+	// and will be retried later.
+	Spooled bool
+	// Filename is the name the archive was stored under. It has a
+	// numbered suffix when the name of the run was taken at this target.
+	Filename string
+}
+
+// Result describes a finished backup run.
+type Result struct {
+	// RunID identifies the run in logs, see logging.NewRunID.
+	RunID string
+	// Started is when the run began.
+	Started time.Time
+	// Instance names the Vaultwarden instance, see Config.Instance.
+	Instance string
+	// Filename is the name of the archive. A destination where the name
+	// was taken stores it with a numbered suffix, see DestinationResult.
+	Filename string
+	// Size is the archive size in bytes, after encryption.
+	Size int64
+	// SHA256 is the hex encoded digest of the archive, after encryption.
+	SHA256 string
+	// Destinations holds one result per target, in target order.
+	Destinations []DestinationResult
+	// Duration is how long the run took, also set for failed runs.
+	Duration time.Duration
+	// DatabaseSize is the size of the database snapshot in bytes.
+	DatabaseSize int64
+	// DatabaseSHA256 is the hex encoded digest of the database snapshot,
+	// which stays the same as long as the data does, see DatabaseDigest.
+	DatabaseSHA256 string
+	// Attachments is the number of attachment files in the archive.
+	Attachments int
+}
+
+// Err returns an error describing all failed targets, or nil.
+// Failed uploads that were spooled for a later retry do not count.
+func (r Result) Err() error {
+	var errs []error
+	for _, d := range r.Destinations {
+		if d.Err != nil && !d.Spooled {
+			errs = append(errs, fmt.Errorf("%s: %w", d.Destination, d.Err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d destinations failed: %w", len(errs), len(r.Destinations), errors.Join(errs...))
+}
+
+// Uploads data to all targets concurrently and applies each target's
+// retention policy after a successful upload. A failing target does not
+// affect the others.
+func distribute(ctx context.Context, targets []Target, filename string, data []byte) []DestinationResult {
+	results := make([]DestinationResult, len(targets))
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Go(func() {
+			results[i] = deliver(ctx, target, filename, bytes.NewReader(data), int64(len(data)))
+		})
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Uploads an archive to a single target and prunes old archives there.
+func deliver(ctx context.Context, target Target, filename string, r io.ReadSeeker, size int64) DestinationResult {
+	dest := target.Destination
+	result := DestinationResult{Destination: dest.String(), Filename: filename}
+
+	name, err := putNumbered(ctx, dest, filename, r)
+	result.Filename = name
+	if err != nil {
+		stage := StageUpload
+		if _, ok := dest.(*destination.Local); ok {
+			stage = StageWrite
+		}
+		result.Err = &StageError{Stage: stage, Err: err}
+		slog.ErrorContext(ctx, "write failed", "stage", stage, "archive", name, "destination", result.Destination, logging.Err(err))
+		return result
+	}
+	slog.InfoContext(ctx, "write successful", "archive", name, "destination", result.Destination, "bytes", size)
+
+	if !target.Retention.IsZero() {
+		result.Pruned, result.PruneErr = prune(ctx, target, name)
+		if result.PruneErr != nil {
+			slog.ErrorContext(ctx, "retention failed", "destination", result.Destination, logging.Err(result.PruneErr))
+		}
+	}
+
+	return result
+}
+
+// Deletes the archives in target that its retention policy does not keep.
+// The archive that was just written is never deleted.
+func prune(ctx context.Context, target Target, current string) ([]string, error) {
+	backups, err := ListBackups(ctx, target.Destination, target.Names)
+	if err != nil {
+		return nil, err
+	}
+
+	_, expired := target.Retention.Select(backups)
+
+	var pruned []string
+	var errs []error
+	for _, obj := range expired {
+		if obj.Name == current {
+			continue
+		}
+		if err := target.Destination.Delete(ctx, obj.Name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pruned = append(pruned, obj.Name)
+	}
+
+	if len(pruned) > 0 {
+		slog.InfoContext(ctx, "retention pruned archives", "destination", target.Destination.String(),
+			"policy", target.Retention.String(), "count", len(pruned), "archives", pruned)
+	}
+
+	return pruned, errors.Join(errs...)
+}
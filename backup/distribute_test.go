@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mijolabs/vaultage/destination"
+	"github.com/mijolabs/vaultage/retention"
+)
+
+// brokenDestination fails every operation, like an unreachable remote.
+type brokenDestination struct{}
+
+func (brokenDestination) Put(ctx context.Context, name string, r io.Reader) error {
+	return errors.New("connection refused")
+}
+func (brokenDestination) List(ctx context.Context) ([]destination.Object, error) {
+	return nil, errors.New("connection refused")
+}
+func (brokenDestination) Delete(ctx context.Context, name string) error {
+	return errors.New("connection refused")
+}
+func (brokenDestination) Stat(ctx context.Context, name string) (destination.Object, error) {
+	return destination.Object{}, errors.New("connection refused")
+}
+func (brokenDestination) String() string { return "broken" }
+
+func TestDistribute_IsolatesFailures(t *testing.T) {
+	ctx := context.Background()
+	local := destination.NewLocal(t.TempDir())
+
+	// Two older archives, one of which the policy prunes
+	for i, name := range []string{"vaultage-20260101_000000.tar.age", "vaultage-20260102_000000.tar.age"} {
+		if err := local.Put(ctx, name, strings.NewReader("old")); err != nil {
+			t.Fatalf("seeding archive: %v", err)
+		}
+		mtime := time.Now().Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(filepath.Join(local.Dir(), name), mtime, mtime); err != nil {
+			t.Fatalf("setting archive mtime: %v", err)
+		}
+	}
+
+	targets := []Target{
+		{Destination: brokenDestination{}},
+		{Destination: local, Retention: retention.Policy{Last: 2}},
+	}
+	results := distribute(ctx, targets, "vaultage-20260103_000000.tar.age", []byte("archive"))
+
+	if results[0].Err == nil {
+		t.Fatal("expected broken destination to fail")
+	}
+	if results[1].Err != nil || results[1].PruneErr != nil {
+		t.Fatalf("expected local destination to succeed, got %v, %v", results[1].Err, results[1].PruneErr)
+	}
+	if len(results[1].Pruned) != 1 || results[1].Pruned[0] != "vaultage-20260101_000000.tar.age" {
+		t.Fatalf("expected one pruned archive, got %v", results[1].Pruned)
+	}
+
+	if _, err := local.Stat(ctx, "vaultage-20260103_000000.tar.age"); err != nil {
+		t.Fatalf("new archive missing: %v", err)
+	}
+
+	err := (Result{Destinations: results}).Err()
+	if err == nil {
+		t.Fatal("expected result error for failed destination")
+	}
+	if stage, ok := ErrorStage(err); !ok || stage != StageUpload {
+		t.Fatalf("expected upload stage, got %q, %t", stage, ok)
+	}
+}
+
+func TestPrune_SkipsFilesThatAreNotArchives(t *testing.T) {
+	ctx := context.Background()
+	local := destination.NewLocal(t.TempDir())
+
+	// Look like archives by prefix and extension, but were not written by vaultage
+	others := []string{"vaultage-old/keep.tar", "vaultage-notes.tar", "2026/vaultage-20260101_000000.tar.age"}
+	for _, name := range append(others, "vaultage-20260101_000000.tar.age") {
+		if err := local.Put(ctx, name, strings.NewReader("old")); err != nil {
+			t.Fatalf("seeding %s: %v", name, err)
+		}
+	}
+
+	targets := []Target{{Destination: local, Retention: retention.Policy{Last: 1}}}
+	results := distribute(ctx, targets, "vaultage-20260102_000000.tar.age", []byte("archive"))
+	if results[0].Err != nil || results[0].PruneErr != nil {
+		t.Fatalf("unexpected errors: %v, %v", results[0].Err, results[0].PruneErr)
+	}
+	if len(results[0].Pruned) != 1 || results[0].Pruned[0] != "vaultage-20260101_000000.tar.age" {
+		t.Fatalf("expected only the old archive to be pruned, got %v", results[0].Pruned)
+	}
+	for _, name := range others {
+		if _, err := local.Stat(ctx, name); err != nil {
+			t.Fatalf("%s was removed: %v", name, err)
+		}
+	}
+}
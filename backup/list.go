@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/mijolabs/vaultage/destination"
+)
+
+const (
+	// Extension of unencrypted backup archives.
+	archiveExt = ".tar"
+	// Extension appended to encrypted backup archives.
+	encryptedExt = ".age"
+)
+
+// Names archives written by versions of vaultage without filename templates.
+var legacyBackupFileName = regexp.MustCompile(`^vaultage-\d{8}_\d{6}\.tar(\.age)?$`)
+
+// Reports whether name is an archive written by earlier versions of
+// vaultage, which are always in the top directory of a destination.
+func isBackupFileName(name string) bool {
+	return legacyBackupFileName.MatchString(name)
+}
+
+// ListBackups returns the backup archives in dest that names recognizes,
+// oldest first. A nil names recognizes the default names. Local
+// directories are only listed as deep as names puts archives, since the
+// output directory may be a large tree such as the home directory.
+func ListBackups(ctx context.Context, dest destination.Destination, names *FilenameTemplate) ([]destination.Object, error) {
+	var objects []destination.Object
+	var err error
+	if local, ok := dest.(*destination.Local); ok {
+		objects, err = local.ListDepth(ctx, names.Depth())
+	} else {
+		objects, err = dest.List(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", dest, err)
+	}
+
+	var backups []destination.Object
+	for _, obj := range objects {
+		if names.Match(obj.Name) {
+			backups = append(backups, obj)
+		}
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ModTime.Before(backups[j].ModTime)
+	})
+
+	return backups, nil
+}
+
+// LatestBackup returns the newest backup archive in the target.
+// The bool result is false when there are no backups yet.
+func LatestBackup(ctx context.Context, target Target) (destination.Object, bool, error) {
+	backups, err := ListBackups(ctx, target.Destination, target.Names)
+	if err != nil || len(backups) == 0 {
+		return destination.Object{}, false, err
+	}
+	return backups[len(backups)-1], true, nil
+}
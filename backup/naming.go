@@ -0,0 +1,283 @@
+package backup
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mijolabs/vaultage/destination"
+)
+
+// DefaultFilenameTemplate names archives vaultage-20060102_150405 in local
+// time, like vaultage always has.
+const DefaultFilenameTemplate = "vaultage-{{.Local.Timestamp}}"
+
+// Upper bound for the numbered names tried when an archive name is taken.
+const maxNameCollisions = 100
+
+// Names archives when Config.FilenameTemplate is not set.
+var defaultFilenameTemplate = mustParseFilenameTemplate(DefaultFilenameTemplate)
+
+// TimeFields holds the parts of a timestamp, formatted with leading zeros.
+type TimeFields struct {
+	Year, Month, Day     string
+	Hour, Minute, Second string
+	// Timestamp is 20060102T150405Z in UTC and 20060102_150405 in local time.
+	Timestamp string
+}
+
+// NameData is what filename templates are executed with. The time fields
+// at the top level are in UTC, those under Local in the local time zone.
+type NameData struct {
+	TimeFields
+	Local    TimeFields
+	Hostname string
+	// Instance is empty unless several instances are configured.
+	Instance string
+	RunID    string
+	Trigger  string
+}
+
+// Returns the template data for a run that started at t.
+func newNameData(t time.Time, hostname, instance, runID, trigger string) NameData {
+	return NameData{
+		TimeFields: timeFields(t.UTC(), "20060102T150405Z"),
+		Local:      timeFields(t.Local(), "20060102_150405"),
+		Hostname:   hostname,
+		Instance:   instance,
+		RunID:      runID,
+		Trigger:    trigger,
+	}
+}
+
+func timeFields(t time.Time, timestampLayout string) TimeFields {
+	return TimeFields{
+		Year:      t.Format("2006"),
+		Month:     t.Format("01"),
+		Day:       t.Format("02"),
+		Hour:      t.Format("15"),
+		Minute:    t.Format("04"),
+		Second:    t.Format("05"),
+		Timestamp: t.Format(timestampLayout),
+	}
+}
+
+// FilenameTemplate names backup archives and recognizes the archives it
+// named when listing a destination. Names may contain slashes to put
+// archives in subdirectories. The archive extension is always appended.
+type FilenameTemplate struct {
+	text    string
+	tmpl    *template.Template
+	pattern *regexp.Regexp
+	// Number of subdirectories the names are in.
+	depth int
+}
+
+// ParseFilenameTemplate parses a text/template naming archives, see
+// NameData for the available fields. Every field must be used as is, so
+// that archive names can be matched against the template.
+func ParseFilenameTemplate(text string) (*FilenameTemplate, error) {
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing filename template: %w", err)
+	}
+	t := &FilenameTemplate{text: text, tmpl: tmpl}
+
+	if t.pattern, err = t.compilePattern(); err != nil {
+		return nil, fmt.Errorf("filename template %q: %w", text, err)
+	}
+	if err := t.Validate("vaultwarden"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func mustParseFilenameTemplate(text string) *FilenameTemplate {
+	t, err := ParseFilenameTemplate(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (t *FilenameTemplate) String() string {
+	return t.text
+}
+
+// Validate renders a sample name for the instance and checks that it is
+// a valid archive name that the template recognizes again.
+func (t *FilenameTemplate) Validate(instance string) error {
+	name, err := t.render(newNameData(time.Now(), hostname(), instance, "0123456789abcdef", "manual"))
+	if err != nil {
+		return err
+	}
+	if !t.pattern.MatchString(name + archiveExt) {
+		return fmt.Errorf("filename template %q: archive names cannot be recognized again, use fields without functions or pipelines", t.text)
+	}
+	return nil
+}
+
+// Renders the name, without extension, and checks that it is a relative
+// slash-separated path.
+func (t *FilenameTemplate) render(data NameData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing filename template: %w", err)
+	}
+	name := buf.String()
+
+	switch {
+	case name == "":
+		return "", fmt.Errorf("filename template %q renders an empty name", t.text)
+	case strings.ContainsAny(name, "\\\x00\n"):
+		return "", fmt.Errorf("filename template %q: name %q contains a backslash or control character", t.text, name)
+	}
+	for part := range strings.SplitSeq(name, "/") {
+		switch {
+		case part == "":
+			return "", fmt.Errorf("filename template %q: name %q has an empty path element or a leading or trailing slash", t.text, name)
+		case part == "." || part == "..":
+			return "", fmt.Errorf("filename template %q: name %q contains %s", t.text, name, part)
+		case strings.HasPrefix(part, "."):
+			// Hidden entries are skipped when listing destinations
+			return "", fmt.Errorf("filename template %q: name %q contains a hidden path element", t.text, name)
+		}
+	}
+	return name, nil
+}
+
+// Patterns the fields of NameData expand to.
+var fieldPatterns = map[string]string{
+	"Year":           `\d{4}`,
+	"Month":          `\d{2}`,
+	"Day":            `\d{2}`,
+	"Hour":           `\d{2}`,
+	"Minute":         `\d{2}`,
+	"Second":         `\d{2}`,
+	"Timestamp":      `\d{8}T\d{6}Z`,
+	"LocalTimestamp": `\d{8}_\d{6}`,
+	"Hostname":       `[^/]*`,
+	"Instance":       `[^/]*`,
+	"RunID":          `[^/]*`,
+	"Trigger":        `[^/]*`,
+}
+
+// Builds a regexp matching the names the template renders, by rendering it
+// with placeholders in place of the fields.
+func (t *FilenameTemplate) compilePattern() (*regexp.Regexp, error) {
+	placeholder := func(field string) string { return "\x00" + field + "\x00" }
+	fields := TimeFields{
+		Year: placeholder("Year"), Month: placeholder("Month"), Day: placeholder("Day"),
+		Hour: placeholder("Hour"), Minute: placeholder("Minute"), Second: placeholder("Second"),
+		Timestamp: placeholder("Timestamp"),
+	}
+	local := fields
+	local.Timestamp = placeholder("LocalTimestamp")
+	data := NameData{
+		TimeFields: fields,
+		Local:      local,
+		Hostname:   placeholder("Hostname"),
+		Instance:   placeholder("Instance"),
+		RunID:      placeholder("RunID"),
+		Trigger:    placeholder("Trigger"),
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	// No field expands to a slash, see fieldPatterns
+	t.depth = strings.Count(buf.String(), "/")
+
+	// Placeholders alternate with literal text
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i, part := range strings.Split(buf.String(), "\x00") {
+		if i%2 == 0 {
+			expr.WriteString(regexp.QuoteMeta(part))
+			continue
+		}
+		p, ok := fieldPatterns[part]
+		if !ok {
+			return nil, errors.New("fields must be used without functions or pipelines")
+		}
+		expr.WriteString(p)
+	}
+	expr.WriteString(`(?:-\d+)?` + regexp.QuoteMeta(archiveExt) + `(?:` + regexp.QuoteMeta(encryptedExt) + `)?$`)
+	return regexp.Compile(expr.String())
+}
+
+// Match reports whether name is an archive named by the template. Names
+// written by earlier versions of vaultage always match, so retention keeps
+// pruning them after switching templates. A nil template matches the
+// names of the default template.
+func (t *FilenameTemplate) Match(name string) bool {
+	if isBackupFileName(name) {
+		return true
+	}
+	return cmp.Or(t, defaultFilenameTemplate).pattern.MatchString(name)
+}
+
+// Stores the archive under filename, or under filename with -2, -3 and so
+// on inserted before the extension if the name is taken. Destinations
+// never replace existing archives, so a name taken concurrently is
+// skipped too. Returns the name the archive was stored under.
+func putNumbered(ctx context.Context, dest destination.Destination, filename string, r io.ReadSeeker) (string, error) {
+	for n := 1; n <= maxNameCollisions; n++ {
+		name := numberedName(filename, n)
+		if n > 1 {
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				return name, fmt.Errorf("rewinding archive: %w", err)
+			}
+		}
+		err := dest.Put(ctx, name, r)
+		if !errors.Is(err, fs.ErrExist) {
+			return name, err
+		}
+		slog.DebugContext(ctx, "archive name taken", "archive", name, "destination", dest.String())
+	}
+	return filename, fmt.Errorf("archive name %s is taken %d times, add a field like {{.RunID}} to the filename template", filename, maxNameCollisions)
+}
+
+// Returns filename with -n inserted before the archive extension, or
+// filename itself for n = 1.
+func numberedName(filename string, n int) string {
+	if n == 1 {
+		return filename
+	}
+	i := strings.LastIndex(filename, archiveExt)
+	if i < 0 {
+		i = len(filename)
+	}
+	return filename[:i] + "-" + strconv.Itoa(n) + filename[i:]
+}
+
+// Depth returns the number of subdirectories the archive names are in,
+// such as 2 for {{.Year}}/{{.Month}}/vaultage-{{.Timestamp}}. A nil
+// template names archives in the top directory.
+func (t *FilenameTemplate) Depth() int {
+	if t == nil {
+		return 0
+	}
+	return t.depth
+}
+
+// Returns the host name for filename templates.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "localhost"
+	}
+	return name
+}
@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mijolabs/vaultage/destination"
+)
+
+func TestFilenameTemplate(t *testing.T) {
+	started := time.Date(2026, 10, 18, 2, 3, 4, 0, time.UTC)
+	data := newNameData(started, "vault.example.com", "family", "abc123", "wal")
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{DefaultFilenameTemplate, "vaultage-" + started.Local().Format("20060102_150405")},
+		{"vaultage-{{.Timestamp}}", "vaultage-20261018T020304Z"},
+		{"{{.Year}}/{{.Month}}/{{.Instance}}-{{.Day}}{{.Hour}}{{.Minute}}{{.Second}}", "2026/10/family-18020304"},
+		{"{{.Hostname}}/{{.Trigger}}-{{.RunID}}", "vault.example.com/wal-abc123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := ParseFilenameTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseFilenameTemplate: %v", err)
+			}
+			got, err := tmpl.render(data)
+			if err != nil || got != tt.want {
+				t.Fatalf("render = %q, %v, want %q", got, err, tt.want)
+			}
+			for _, name := range []string{got + ".tar", got + ".tar.age", got + "-2.tar.age"} {
+				if !tmpl.Match(name) {
+					t.Errorf("Match(%q) = false", name)
+				}
+			}
+			if tmpl.Match(got + ".zip") {
+				t.Errorf("Match(%q) = true", got+".zip")
+			}
+		})
+	}
+}
+
+func TestFilenameTemplate_Match(t *testing.T) {
+	tmpl, err := ParseFilenameTemplate("{{.Year}}/{{.Month}}/vw-{{.Timestamp}}")
+	if err != nil {
+		t.Fatalf("ParseFilenameTemplate: %v", err)
+	}
+	if tmpl.Depth() != 2 {
+		t.Fatalf("Depth = %d, want 2", tmpl.Depth())
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"2026/10/vw-20261018T020304Z.tar.age", true},
+		// Archives named by the default template are still pruned
+		{"vaultage-20261018_020304.tar.age", true},
+		{"2026/10/vw-latest.tar.age", false},
+		{"2026/vw-20261018T020304Z.tar.age", false},
+		{"notes/2026/10/vw-20261018T020304Z.tar.age", false},
+	}
+	for _, tt := range tests {
+		if got := tmpl.Match(tt.name); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilenameTemplate_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"syntax":        "vaultage-{{.Year",
+		"unknown field": "vaultage-{{.Weekday}}",
+		"empty":         "",
+		"absolute":      "/backups/vaultage-{{.Timestamp}}",
+		"parent":        "../vaultage-{{.Timestamp}}",
+		"empty element": "{{.Year}}//vaultage",
+		"hidden":        ".vaultage/{{.Timestamp}}",
+		"function":      "vaultage-{{slice .Year 2}}",
+	}
+	for name, text := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseFilenameTemplate(text); err == nil {
+				t.Fatalf("ParseFilenameTemplate(%q) succeeded", text)
+			}
+		})
+	}
+
+	// An empty instance name leaves an empty path element
+	tmpl, err := ParseFilenameTemplate("{{.Instance}}/vaultage-{{.Timestamp}}")
+	if err != nil {
+		t.Fatalf("ParseFilenameTemplate: %v", err)
+	}
+	if err := tmpl.Validate(""); err == nil || !strings.Contains(err.Error(), "leading") {
+		t.Fatalf("expected error for empty instance, got %v", err)
+	}
+}
+
+func TestDistribute_NameTaken(t *testing.T) {
+	ctx := context.Background()
+	first := destination.NewLocal(t.TempDir())
+	second := destination.NewLocal(t.TempDir())
+	targets := []Target{{Destination: first}, {Destination: second}}
+
+	// The name is taken in the first target only, the second keeps it
+	if err := first.Put(ctx, "2026/vaultage.tar.age", strings.NewReader("older")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	want := [][]string{
+		{"2026/vaultage-2.tar.age", "2026/vaultage.tar.age"},
+		{"2026/vaultage-3.tar.age", "2026/vaultage-2.tar.age"},
+	}
+	for _, w := range want {
+		results := distribute(ctx, targets, "2026/vaultage.tar.age", []byte("archive"))
+		for i, r := range results {
+			if r.Err != nil || r.Filename != w[i] {
+				t.Fatalf("target %d stored %q, %v, want %q", i, r.Filename, r.Err, w[i])
+			}
+		}
+	}
+
+	// The existing archive is left untouched
+	if b, err := os.ReadFile(filepath.Join(first.Dir(), "2026", "vaultage.tar.age")); err != nil || string(b) != "older" {
+		t.Fatalf("existing archive = %q, %v", b, err)
+	}
+}
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		filename string
+		n        int
+		want     string
+	}{
+		{"vaultage-20261018_020304.tar.age", 1, "vaultage-20261018_020304.tar.age"},
+		{"vaultage-20261018_020304.tar.age", 2, "vaultage-20261018_020304-2.tar.age"},
+		{"2026/vaultage.tar", 3, "2026/vaultage-3.tar"},
+	}
+	for _, tt := range tests {
+		if got := numberedName(tt.filename, tt.n); got != tt.want {
+			t.Errorf("numberedName(%q, %d) = %q, want %q", tt.filename, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mijolabs/vaultage/logging"
+)
+
+// SnapshotStrategy selects how the live database is captured.
+type SnapshotStrategy string
+
+const (
+	// SnapshotAuto uses the online backup when the data directory is
+	// writable and falls back to SnapshotCopy otherwise.
+	SnapshotAuto SnapshotStrategy = "auto"
+	// SnapshotOnline runs the SQLite Online Backup API directly against the
+	// live database. SQLite may need to create or write the -shm file.
+	SnapshotOnline SnapshotStrategy = "online"
+	// SnapshotCopy never writes to the data directory. The database is read
+	// as immutable when there is no WAL content, otherwise the database and
+	// WAL are copied into a private temp directory and snapshotted there.
+	SnapshotCopy SnapshotStrategy = "copy"
+)
+
+const (
+	// Suffixes of the SQLite WAL and shared-memory files.
+	walSuffix = "-wal"
+	shmSuffix = "-shm"
+	// How many times a read-only snapshot is retried when the database
+	// changes while it is being read.
+	snapshotAttempts = 5
+	// Pause between read-only snapshot attempts.
+	snapshotRetryDelay = 200 * time.Millisecond
+)
+
+// ParseSnapshotStrategy validates a snapshot strategy name.
+// An empty string selects SnapshotAuto.
+func ParseSnapshotStrategy(s string) (SnapshotStrategy, error) {
+	switch SnapshotStrategy(s) {
+	case "", SnapshotAuto:
+		return SnapshotAuto, nil
+	case SnapshotOnline, SnapshotCopy:
+		return SnapshotStrategy(s), nil
+	default:
+		return "", fmt.Errorf("unknown snapshot mode %q (expected auto, online or copy)", s)
+	}
+}
+
+// SnapshotDatabase returns a consistent copy of the database at dbPath
+// using the given strategy.
+func SnapshotDatabase(ctx context.Context, dbPath string, strategy SnapshotStrategy) ([]byte, error) {
+	strategy, err := ParseSnapshotStrategy(string(strategy))
+	if err != nil {
+		return nil, err
+	}
+
+	if strategy == SnapshotAuto {
+		strategy = SnapshotOnline
+		if !canWriteSidecarFiles(dbPath) {
+			slog.InfoContext(ctx, "data directory is not writable, using read-only snapshot", "path", filepath.Dir(dbPath))
+			strategy = SnapshotCopy
+		}
+	}
+
+	if strategy == SnapshotCopy {
+		return BackupReadOnly(ctx, dbPath)
+	}
+	return BackupToMemory(dbPath)
+}
+
+// Reports whether SQLite will be able to create or update the -shm file
+// next to dbPath, which the online backup of a WAL database relies on.
+func canWriteSidecarFiles(dbPath string) bool {
+	if !isWritable(filepath.Dir(dbPath)) {
+		return false
+	}
+	shmPath := dbPath + shmSuffix
+	if _, err := os.Stat(shmPath); err == nil && !isWritable(shmPath) {
+		return false
+	}
+	return true
+}
+
+// BackupReadOnly snapshots the database at dbPath without writing anything
+// next to it, which makes it safe on read-only mounts.
+//
+// Without WAL content the database file is opened with immutable=1.
+// Otherwise the database and WAL are copied into a private temp directory,
+// where SQLite can rebuild the -shm index and run the online backup.
+// In both cases the source files are fingerprinted before and after the read,
+// and the snapshot is retried if the database changed in the meantime.
+func BackupReadOnly(ctx context.Context, dbPath string) ([]byte, error) {
+	var lastErr error
+	for attempt := 1; attempt <= snapshotAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(snapshotRetryDelay):
+			}
+		}
+
+		before, err := fingerprintDatabase(dbPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var data []byte
+		if before.walSize == 0 {
+			data, err = BackupToMemory(immutableURI(dbPath))
+		} else {
+			data, err = backupFromCopy(dbPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		after, err := fingerprintDatabase(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		if before == after {
+			return data, nil
+		}
+
+		lastErr = errors.New("database changed while it was being read")
+		slog.WarnContext(ctx, "read-only snapshot inconsistent", "stage", StageSnapshot, "path", dbPath,
+			"attempt", attempt, "max_attempts", snapshotAttempts, logging.Err(lastErr))
+	}
+
+	return nil, fmt.Errorf("no consistent snapshot after %d attempts: %w", snapshotAttempts, lastErr)
+}
+
+// Builds an SQLite URI that opens dbPath without taking locks or touching
+// any sidecar files.
+func immutableURI(dbPath string) string {
+	if abs, err := filepath.Abs(dbPath); err == nil {
+		dbPath = abs
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(dbPath), RawQuery: "immutable=1"}
+	return u.String()
+}
+
+// Copies the database and its WAL into a private temp directory and runs
+// the online backup against the copy.
+func backupFromCopy(dbPath string) ([]byte, error) {
+	tmpDir, err := os.MkdirTemp("", "vaultage-snapshot-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	copyPath := filepath.Join(tmpDir, filepath.Base(dbPath))
+	if err := copyFile(dbPath, copyPath); err != nil {
+		return nil, err
+	}
+	// The -shm file is deliberately left behind, SQLite rebuilds
+	// the WAL index from the WAL when it is missing.
+	if err := copyFile(dbPath+walSuffix, copyPath+walSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return BackupToMemory(copyPath)
+}
+
+// Copies a single file, creating dst with owner-only permissions.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+
+	return out.Close()
+}
+
+// dbFingerprint identifies the on-disk state of a database and its WAL.
+type dbFingerprint struct {
+	dbSize     int64
+	dbModTime  int64
+	walSize    int64
+	walModTime int64
+}
+
+// Captures size and modification time of the database and its WAL.
+// A missing WAL is recorded as empty.
+func fingerprintDatabase(dbPath string) (dbFingerprint, error) {
+	var fp dbFingerprint
+
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		return fp, fmt.Errorf("stat database: %w", err)
+	}
+	fp.dbSize = info.Size()
+	fp.dbModTime = info.ModTime().UnixNano()
+
+	info, err = os.Stat(dbPath + walSuffix)
+	switch {
+	case err == nil:
+		fp.walSize = info.Size()
+		fp.walModTime = info.ModTime().UnixNano()
+	case !errors.Is(err, os.ErrNotExist):
+		return fp, fmt.Errorf("stat WAL: %w", err)
+	}
+
+	return fp, nil
+}
@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestBackupReadOnly_WALMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		PRAGMA journal_mode=WAL;
+		PRAGMA wal_autocheckpoint=0;
+		CREATE TABLE items (id INTEGER PRIMARY KEY, value TEXT);
+		INSERT INTO items (value) VALUES ('one'), ('two'), ('three');
+	`)
+	if err != nil {
+		t.Fatalf("creating test data: %v", err)
+	}
+
+	// The rows only exist in the WAL at this point
+	if info, err := os.Stat(dbPath + walSuffix); err != nil || info.Size() == 0 {
+		t.Fatalf("expected non-empty WAL file, got %v", err)
+	}
+
+	data, err := BackupReadOnly(context.Background(), dbPath)
+	if err != nil {
+		t.Fatalf("BackupReadOnly: %v", err)
+	}
+
+	if got := countRows(t, data, "items"); got != 3 {
+		t.Fatalf("expected 3 items, got %d", got)
+	}
+}
+
+func TestBackupReadOnly_NoWAL(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO users (name) VALUES ('alice'), ('bob');
+	`)
+	if err != nil {
+		t.Fatalf("creating test data: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+
+	data, err := BackupReadOnly(context.Background(), dbPath)
+	if err != nil {
+		t.Fatalf("BackupReadOnly: %v", err)
+	}
+
+	if got := countRows(t, data, "users"); got != 2 {
+		t.Fatalf("expected 2 users, got %d", got)
+	}
+
+	// Nothing may be created next to the source database
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("reading temp dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the database file, found %d entries", len(entries))
+	}
+}
+
+func TestParseSnapshotStrategy(t *testing.T) {
+	if s, err := ParseSnapshotStrategy(""); err != nil || s != SnapshotAuto {
+		t.Fatalf("empty strategy: got %q, %v", s, err)
+	}
+	if _, err := ParseSnapshotStrategy("bogus"); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+}
+
+// Writes a serialized database to disk and counts the rows in table.
+func countRows(t *testing.T, data []byte, table string) int {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("writing snapshot: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("opening snapshot: %v", err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatalf("querying snapshot: %v", err)
+	}
+	return count
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/mijolabs/vaultage/logging"
+	"github.com/mijolabs/vaultage/spool"
+)
+
+// Bounds for the backoff between retries of a spooled upload.
+const (
+	spoolRetryMinDelay = 1 * time.Minute
+	spoolRetryMaxDelay = 1 * time.Hour
+)
+
+// Queues the archive in the spool for every target it could not be written to.
+func spoolFailed(ctx context.Context, sp *spool.Spool, results []DestinationResult, data []byte) {
+	for i := range results {
+		if results[i].Err == nil {
+			continue
+		}
+		filename := results[i].Filename
+		if _, err := sp.Add(results[i].Destination, filename, data); err != nil {
+			slog.ErrorContext(ctx, "spooling failed", "archive", filename, "destination", results[i].Destination, logging.Err(err))
+			continue
+		}
+		results[i].Spooled = true
+		slog.WarnContext(ctx, "upload queued in spool", "archive", filename, "destination", results[i].Destination, "path", sp.Dir())
+	}
+}
+
+// FlushSpool retries the spooled uploads that are due and removes them from
+// the spool once the upload is confirmed. Failed uploads are rescheduled
+// with exponential backoff. Entries for destinations that are not among
+// targets are kept, in case the destination is configured again later.
+func FlushSpool(ctx context.Context, sp *spool.Spool, targets []Target) []DestinationResult {
+	due, err := sp.Due(time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "reading spool failed", "path", sp.Dir(), logging.Err(err))
+		return nil
+	}
+
+	byName := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		byName[t.Destination.String()] = t
+	}
+
+	var results []DestinationResult
+	for _, entry := range due {
+		if ctx.Err() != nil {
+			break
+		}
+
+		target, ok := byName[entry.Destination]
+		if !ok {
+			continue
+		}
+
+		slog.InfoContext(ctx, "retrying spooled upload", "archive", entry.Filename, "destination", entry.Destination, "attempt", entry.Attempts+1)
+		result := deliverSpooled(ctx, sp, target, entry)
+		results = append(results, result)
+
+		if result.Err != nil {
+			next := time.Now().Add(spoolRetryDelay(entry.Attempts + 1))
+			if err := sp.Failed(entry.ID, result.Err, next); err != nil {
+				slog.ErrorContext(ctx, "updating spool failed", "path", sp.Dir(), logging.Err(err))
+			}
+			continue
+		}
+		if err := sp.Done(entry.ID); err != nil {
+			slog.ErrorContext(ctx, "updating spool failed", "path", sp.Dir(), logging.Err(err))
+		}
+	}
+
+	return results
+}
+
+// Uploads a spooled archive from disk.
+func deliverSpooled(ctx context.Context, sp *spool.Spool, target Target, entry spool.Entry) DestinationResult {
+	f, err := os.Open(sp.Path(entry))
+	if err != nil {
+		return DestinationResult{Destination: entry.Destination, Err: fmt.Errorf("opening spooled archive: %w", err)}
+	}
+	defer f.Close()
+
+	return deliver(ctx, target, entry.Filename, f, entry.Size)
+}
+
+// Returns the delay before the given retry attempt (1-based).
+func spoolRetryDelay(attempt int) time.Duration {
+	d := spoolRetryMinDelay
+	for i := 1; i < attempt && d < spoolRetryMaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, spoolRetryMaxDelay)
+}
@@ -0,0 +1,42 @@
+package backup
+
+import "errors"
+
+// Stage identifies the step of a backup run that failed.
+type Stage string
+
+const (
+	StageSnapshot Stage = "snapshot"
+	StageArchive  Stage = "archive"
+	StageEncrypt  Stage = "encrypt"
+	// StageWrite is storing the archive in a local directory.
+	StageWrite Stage = "write"
+	// StageUpload is storing the archive in a remote destination.
+	StageUpload Stage = "upload"
+)
+
+// Stages lists all stages in the order they run.
+var Stages = []Stage{StageSnapshot, StageArchive, StageEncrypt, StageWrite, StageUpload}
+
+// StageError attributes an error to the stage it occurred in.
+type StageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorStage returns the stage err occurred in, if known.
+func ErrorStage(err error) (Stage, bool) {
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		return stageErr.Stage, true
+	}
+	return "", false
+}
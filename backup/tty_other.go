@@ -0,0 +1,14 @@
+//go:build !unix
+
+package backup
+
+import (
+	"errors"
+	"os"
+)
+
+// Opens the controlling terminal of the process.
+// Non-unix platforms are not supported, so prompting requires stdin to be a terminal.
+func openTTY() (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package backup
+
+import "os"
+
+// Opens the controlling terminal of the process.
+func openTTY() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
@@ -2,11 +2,16 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 
 	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/history"
+	"github.com/mijolabs/vaultage/logging"
+	"github.com/mijolabs/vaultage/notify"
 )
 
 // Creates a Cobra command that performs a backup of Vaultwarden data,
@@ -16,26 +21,90 @@ func Backup(ctx context.Context) *cobra.Command {
 		Short: "One-shot backup and archive of Vaultwarden data",
 		Use:   "backup [data dir]",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dataDir, err := validateDataDirFromArgs(args)
+			instances, err := resolveInstances(cmd, addBackupCommandFlags)
 			if err != nil {
-				return fmt.Errorf("validating data directory: %w", err)
+				return err
 			}
+			if len(instances) > 1 && len(args) > 0 {
+				return fmt.Errorf("a data directory argument cannot be used with several instances, set data-dir in the config file")
+			}
+
+			// Read the passphrase before running any passphrase command,
+			// which shares stdin
+			var stdinPassphrase string
+			if passphraseStdin, _ := cmd.Flags().GetBool("passphrase-stdin"); passphraseStdin {
+				stdinPassphrase, err = readPassphraseLine(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("reading passphrase from stdin: %w", err)
+				}
+			}
+
+			// Resolve all instances before backing up any of them
+			cfgs := make([]backup.Config, len(instances))
+			notifiers := make([]*notify.Dispatcher, len(instances))
+			pings := make([]*notify.Ping, len(instances))
+			for i, inst := range instances {
+				cfgs[i], err = resolveInstanceConfig(ctx, inst, args)
+				if err != nil {
+					return inst.wrap(err)
+				}
+				cfgs[i].Trigger = history.TriggerManual
 
-			cfg := resolveBackupFlags(cmd)
-			cfg.DataDir = dataDir
+				if stdinPassphrase != "" && !cfgs[i].WithoutEncryption {
+					if cfgs[i].AgePassphrase != "" {
+						return inst.wrap(fmt.Errorf("--passphrase-stdin cannot be combined with --age-passphrase or --age-passphrase-command"))
+					}
+					cfgs[i].AgePassphrase = stdinPassphrase
+				}
 
-			// Validate mutually exclusive age options
-			if !cfg.WithoutEncryption {
-				if cfg.AgePassphrase != "" && cfg.AgeKeyFile != "" {
-					return fmt.Errorf("--age-passphrase and --age-key-file are mutually exclusive")
+				// Validate mutually exclusive age options
+				if !cfgs[i].WithoutEncryption {
+					if cfgs[i].AgePassphrase != "" && cfgs[i].AgeKeyFile != "" {
+						return inst.wrap(fmt.Errorf("--age-passphrase and --age-key-file are mutually exclusive"))
+					}
 				}
+
+				notifiers[i], pings[i], err = resolveNotifyFlags(inst.cmd, cfgs[i].DataDir)
+				if err != nil {
+					return inst.wrap(err)
+				}
+			}
+			if err := checkInstanceConflicts(cfgs); err != nil {
+				return err
 			}
 
-			return backup.Perform(ctx, cfg)
+			var errs []error
+			for i, inst := range instances {
+				ctx := ctx
+				if inst.name != "" {
+					ctx = logging.With(ctx, "instance", inst.name)
+				}
+
+				// Deliver archives left over from earlier runs first
+				if cfgs[i].Spool != nil {
+					backup.FlushSpool(ctx, cfgs[i].Spool, cfgs[i].OutputTargets())
+				}
+
+				pingStart(ctx, pings[i])
+				result, err := backup.Perform(ctx, cfgs[i])
+				notifiers[i].Dispatch(ctx, notify.Events(result, err)...)
+				notifiers[i].Wait()
+				if err != nil {
+					errs = append(errs, inst.wrap(err))
+				}
+			}
+			return errors.Join(errs...)
 		},
 	}
 
-	addBackupFlags(cmd)
+	addBackupCommandFlags(cmd)
 
 	return cmd
 }
+
+// Registers the flags of the backup command.
+func addBackupCommandFlags(cmd *cobra.Command) {
+	addBackupFlags(cmd)
+	addNotifyFlags(cmd)
+	cmd.Flags().Bool("passphrase-stdin", false, "read the age passphrase from the first line of stdin")
+}
@@ -0,0 +1,377 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/destination"
+)
+
+// settings maps flag names to values, as they would be given on the
+// command line. Lists hold one value per repetition of the flag.
+type settings map[string][]string
+
+// configFile is a parsed --config file. Top-level settings apply to every
+// instance, the settings of an instance override them.
+type configFile struct {
+	path   string
+	global settings
+	// Instances in file order, empty when the file configures a single
+	// instance with its top-level settings.
+	instances []instanceConfig
+}
+
+type instanceConfig struct {
+	name     string
+	settings settings
+}
+
+// Settings that apply to the whole process and cannot differ between
+// instances.
+var processSettings = []string{"listen", "log-format", "log-level", "instance"}
+
+// Valid instance names, which end up in logs, metric labels and paths.
+var instanceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// Reads the config file named by --config or VAULTAGE_CONFIG, returning
+// nil when there is none. Settings are checked against the flags of all
+// commands, so a file can be shared between them.
+func loadConfigFile(cmd *cobra.Command) (*configFile, error) {
+	path, _ := cmd.Flags().GetString("config")
+	if !cmd.Flags().Changed("config") {
+		path = envStringOrDefault("VAULTAGE_CONFIG", path)
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	file, err := parseConfigFile(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	known := knownSettings(cmd.Root())
+	check := func(s settings, instance string) error {
+		for name := range s {
+			if name == "config" {
+				return fmt.Errorf("%s: config cannot be set in the config file", path)
+			}
+			if !known[name] {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+			if instance != "" && slices.Contains(processSettings, name) {
+				return fmt.Errorf("%s: %q can only be set at the top level, not for instance %q", path, name, instance)
+			}
+		}
+		return nil
+	}
+	if err := check(file.global, ""); err != nil {
+		return nil, err
+	}
+	for _, inst := range file.instances {
+		if err := check(inst.settings, inst.name); err != nil {
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// Parses a YAML config file.
+func parseConfigFile(path string, data []byte) (*configFile, error) {
+	file := &configFile{path: path, global: settings{}}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return file, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a mapping of settings", path, root.Line)
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "instances" {
+			values, err := settingValues(path, key, value)
+			if err != nil {
+				return nil, err
+			}
+			file.global[key.Value] = values
+			continue
+		}
+
+		if value.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s:%d: instances must map instance names to their settings", path, value.Line)
+		}
+		for j := 0; j < len(value.Content); j += 2 {
+			nameNode, body := value.Content[j], value.Content[j+1]
+			if !instanceNamePattern.MatchString(nameNode.Value) {
+				return nil, fmt.Errorf("%s:%d: invalid instance name %q, use letters, digits, - and _", path, nameNode.Line, nameNode.Value)
+			}
+			if body.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("%s:%d: expected a mapping of settings for instance %q", path, body.Line, nameNode.Value)
+			}
+
+			inst := instanceConfig{name: nameNode.Value, settings: settings{}}
+			for k := 0; k < len(body.Content); k += 2 {
+				values, err := settingValues(path, body.Content[k], body.Content[k+1])
+				if err != nil {
+					return nil, err
+				}
+				inst.settings[body.Content[k].Value] = values
+			}
+			file.instances = append(file.instances, inst)
+		}
+		if len(file.instances) == 0 {
+			return nil, fmt.Errorf("%s:%d: instances is empty", path, value.Line)
+		}
+	}
+
+	return file, nil
+}
+
+// Returns the values of one setting, which is a scalar or a list of scalars.
+func settingValues(path string, key, value *yaml.Node) ([]string, error) {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return []string{value.Value}, nil
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(value.Content))
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("%s:%d: %s: expected a list of values", path, item.Line, key.Value)
+			}
+			values = append(values, item.Value)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%s:%d: %s: expected a value or a list of values", path, value.Line, key.Value)
+	}
+}
+
+// Returns the names of all flags of cmd and its subcommands.
+func knownSettings(cmd *cobra.Command) map[string]bool {
+	known := map[string]bool{}
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		c.Flags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+		c.PersistentFlags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(cmd)
+	delete(known, "help")
+	return known
+}
+
+// Makes the settings the defaults of the flags in fs that were not set on
+// the command line, so that env vars still take precedence over them.
+// Settings without a flag in fs are ignored.
+func applySettings(fs *pflag.FlagSet, s settings) error {
+	for name, values := range s {
+		f := fs.Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			if err := sv.Replace(values); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			continue
+		}
+		if len(values) != 1 {
+			return fmt.Errorf("%s: expected a single value", name)
+		}
+		if err := f.Value.Set(values[0]); err != nil {
+			return fmt.Errorf("%s: invalid value %q: %w", name, values[0], err)
+		}
+	}
+	return nil
+}
+
+// Applies the top-level settings of the config file to the flags of cmd,
+// including the persistent flags inherited from the root command.
+func applyConfigFile(cmd *cobra.Command) error {
+	file, err := loadConfigFile(cmd)
+	if err != nil || file == nil {
+		return err
+	}
+	if err := applySettings(cmd.Flags(), file.global); err != nil {
+		return fmt.Errorf("%s: %w", file.path, err)
+	}
+	return nil
+}
+
+// instance is one Vaultwarden instance to run a command for. Its command
+// carries the flags of the original command, with the instance's settings
+// as defaults.
+type instance struct {
+	// Empty unless the config file names its instances.
+	name string
+	cmd  *cobra.Command
+	// The config file settings applied to cmd.
+	settings settings
+}
+
+// Returns the error prefixed with the instance name, if any.
+func (inst instance) wrap(err error) error {
+	if err == nil || inst.name == "" {
+		return err
+	}
+	return fmt.Errorf("instance %s: %w", inst.name, err)
+}
+
+// Returns the instances to run cmd for: the instances of the config file,
+// limited to those selected with --instance, or a single unnamed instance.
+// addFlags registers the flags the instance commands need.
+func resolveInstances(cmd *cobra.Command, addFlags func(*cobra.Command)) ([]instance, error) {
+	file, err := loadConfigFile(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, _ := cmd.Flags().GetStringArray("instance")
+	if !cmd.Flags().Changed("instance") {
+		selected = envFieldsOrDefault("VAULTAGE_INSTANCE", selected)
+	}
+
+	configs := []instanceConfig{{}}
+	var global settings
+	if file != nil {
+		global = file.global
+		if len(file.instances) > 0 {
+			configs = file.instances
+		}
+	}
+	if len(selected) > 0 {
+		if file == nil || len(file.instances) == 0 {
+			return nil, errors.New("--instance requires a config file with instances")
+		}
+		var filtered []instanceConfig
+		for _, name := range selected {
+			i := slices.IndexFunc(configs, func(c instanceConfig) bool { return c.name == name })
+			if i < 0 {
+				return nil, fmt.Errorf("instance %q is not in %s", name, file.path)
+			}
+			filtered = append(filtered, configs[i])
+		}
+		configs = filtered
+	}
+
+	instances := make([]instance, 0, len(configs))
+	for _, c := range configs {
+		inst := instance{name: c.name, cmd: &cobra.Command{Use: cmd.Use}, settings: settings{}}
+		addFlags(inst.cmd)
+		copyChangedFlags(cmd.Flags(), inst.cmd.Flags())
+
+		// Instance settings override the top-level ones
+		for _, s := range []settings{global, c.settings} {
+			if err := applySettings(inst.cmd.Flags(), s); err != nil {
+				return nil, inst.wrap(fmt.Errorf("%s: %w", file.path, err))
+			}
+			maps.Copy(inst.settings, s)
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+// Copies the values of the flags set on the command line from src to dst.
+func copyChangedFlags(src, dst *pflag.FlagSet) {
+	src.Visit(func(f *pflag.Flag) {
+		target := dst.Lookup(f.Name)
+		if target == nil {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			target.Value.(pflag.SliceValue).Replace(sv.GetSlice())
+		} else {
+			target.Value.Set(f.Value.String())
+		}
+		target.Changed = true
+	})
+}
+
+// Reads the backup settings of one instance.
+func resolveInstanceConfig(ctx context.Context, inst instance, args []string) (backup.Config, error) {
+	dataDir, err := resolveDataDir(inst.cmd, args)
+	if err != nil {
+		return backup.Config{}, fmt.Errorf("validating data directory: %w", err)
+	}
+
+	cfg, err := resolveBackupFlags(inst.cmd)
+	if err != nil {
+		return backup.Config{}, err
+	}
+	cfg.DataDir = dataDir
+	cfg.Instance = inst.name
+
+	if err := resolvePassphraseCommand(ctx, inst.cmd, &cfg); err != nil {
+		return backup.Config{}, err
+	}
+
+	// The instance name may be part of the archive names
+	if err := cfg.FilenameTemplate.Validate(cfg.Instance); err != nil {
+		return backup.Config{}, err
+	}
+
+	// Validate snapshot mode
+	if _, err := backup.ParseSnapshotStrategy(string(cfg.Snapshot)); err != nil {
+		return backup.Config{}, err
+	}
+	return cfg, nil
+}
+
+// Checks that no two instances share a data directory, state directory or
+// destination, where they would overwrite or prune each other's backups.
+func checkInstanceConflicts(cfgs []backup.Config) error {
+	owners := map[string]string{}
+	claim := func(what, key, name string) error {
+		if other, ok := owners[what+"\x00"+key]; ok {
+			return fmt.Errorf("instances %s and %s use the same %s %s", other, name, what, key)
+		}
+		owners[what+"\x00"+key] = name
+		return nil
+	}
+
+	for _, cfg := range cfgs {
+		dataDir, _ := filepath.Abs(cfg.DataDir)
+		stateDir, _ := filepath.Abs(cfg.StateDir)
+		if err := claim("data directory", dataDir, cfg.Instance); err != nil {
+			return err
+		}
+		if err := claim("state directory", stateDir, cfg.Instance); err != nil {
+			return err
+		}
+		for _, t := range cfg.OutputTargets() {
+			key := t.Destination.String()
+			if local, ok := t.Destination.(*destination.Local); ok {
+				key, _ = filepath.Abs(local.Dir())
+			}
+			if err := claim("destination", key, cfg.Instance); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
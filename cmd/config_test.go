@@ -0,0 +1,434 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/spf13/cobra"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/destination"
+)
+
+// Writes a config file and returns the backup command of a fresh command
+// tree, with args parsed as its command line.
+func backupCmdWithConfig(t *testing.T, config string, args ...string) *cobra.Command {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vaultage.yaml")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	root := RootCmd(context.Background())
+	cmd, _, err := root.Find([]string{"backup"})
+	if err != nil {
+		t.Fatalf("finding backup command: %v", err)
+	}
+	if err := cmd.ParseFlags(append([]string{"--config", path}, args...)); err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+	return cmd
+}
+
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		global    settings
+		instances []instanceConfig
+		err       string
+	}{
+		{name: "empty", yaml: "", global: settings{}},
+		{
+			name:   "scalars and lists",
+			yaml:   "output-dir: /backups\nspool-max-size: 10\ndestination:\n  - /a\n  - s3://bucket\n",
+			global: settings{"output-dir": {"/backups"}, "spool-max-size": {"10"}, "destination": {"/a", "s3://bucket"}},
+		},
+		{
+			name:   "instances",
+			yaml:   "log-level: debug\ninstances:\n  family:\n    data-dir: /srv/family\n  work:\n    data-dir: /srv/work\n",
+			global: settings{"log-level": {"debug"}},
+			instances: []instanceConfig{
+				{name: "family", settings: settings{"data-dir": {"/srv/family"}}},
+				{name: "work", settings: settings{"data-dir": {"/srv/work"}}},
+			},
+		},
+		{name: "not a mapping", yaml: "- /backups\n", err: "expected a mapping of settings"},
+		{name: "nested mapping", yaml: "output-dir:\n  path: /backups\n", err: "expected a value or a list of values"},
+		{name: "nested list", yaml: "destination:\n  - [/a]\n", err: "expected a list of values"},
+		{name: "instances not a mapping", yaml: "instances:\n  - family\n", err: "instances must map instance names"},
+		{name: "empty instances", yaml: "instances: {}\n", err: "instances is empty"},
+		{name: "invalid instance name", yaml: "instances:\n  fam ily:\n    data-dir: /srv\n", err: "invalid instance name"},
+		{name: "instance without settings", yaml: "instances:\n  family: /srv\n", err: "expected a mapping of settings for instance"},
+		{name: "invalid yaml", yaml: "output-dir: [\n", err: "parsing vaultage.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := parseConfigFile("vaultage.yaml", []byte(tt.yaml))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfigFile: %v", err)
+			}
+			if !maps.EqualFunc(file.global, tt.global, slices.Equal) {
+				t.Errorf("global = %v, want %v", file.global, tt.global)
+			}
+			if len(file.instances) != len(tt.instances) {
+				t.Fatalf("instances = %v, want %v", file.instances, tt.instances)
+			}
+			for i, inst := range file.instances {
+				want := tt.instances[i]
+				if inst.name != want.name || !maps.EqualFunc(inst.settings, want.settings, slices.Equal) {
+					t.Errorf("instance %d = %v, want %v", i, inst, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplySettings_Precedence(t *testing.T) {
+	tests := []struct {
+		name string
+		flag string
+		env  string
+		file string
+		want string
+	}{
+		{name: "default", want: "."},
+		{name: "file", file: "/file", want: "/file"},
+		{name: "env over file", env: "/env", file: "/file", want: "/env"},
+		{name: "flag over env and file", flag: "/flag", env: "/env", file: "/file", want: "/flag"},
+		{name: "flag over default", flag: "/flag", want: "/flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VAULTAGE_OUTPUT_DIR", tt.env)
+			t.Setenv("VAULTAGE_DESTINATION", "")
+
+			cmd := &cobra.Command{}
+			addBackupFlags(cmd)
+			var args []string
+			if tt.flag != "" {
+				args = []string{"--output-dir", tt.flag}
+			}
+			if err := cmd.ParseFlags(args); err != nil {
+				t.Fatalf("parsing flags: %v", err)
+			}
+			s := settings{}
+			if tt.file != "" {
+				s["output-dir"] = []string{tt.file}
+			}
+			if err := applySettings(cmd.Flags(), s); err != nil {
+				t.Fatalf("applySettings: %v", err)
+			}
+
+			cfg, err := resolveBackupSettings(cmd)
+			if err != nil {
+				t.Fatalf("resolveBackupSettings: %v", err)
+			}
+			if cfg.OutputDir != tt.want {
+				t.Fatalf("output dir = %q, want %q", cfg.OutputDir, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplySettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		settings settings
+		want     map[string]string
+		err      string
+	}{
+		{
+			name:     "list replaces the default",
+			settings: settings{"destination": {"/a", "/b"}},
+			want:     map[string]string{"destination": "[/a,/b]"},
+		},
+		{
+			name:     "list set on the command line is kept",
+			args:     []string{"--destination", "/cli"},
+			settings: settings{"destination": {"/a", "/b"}},
+			want:     map[string]string{"destination": "[/cli]"},
+		},
+		{
+			name:     "scalars",
+			settings: settings{"spool-max-size": {"10"}, "without-encryption": {"true"}},
+			want:     map[string]string{"spool-max-size": "10", "without-encryption": "true"},
+		},
+		{
+			// Settings for the flags of other commands are left alone
+			name:     "flag of another command",
+			settings: settings{"listen": {":8080"}},
+			want:     map[string]string{"output-dir": "."},
+		},
+		{name: "list for a scalar", settings: settings{"output-dir": {"/a", "/b"}}, err: "expected a single value"},
+		{name: "invalid value", settings: settings{"spool-max-size": {"lots"}}, err: `invalid value "lots"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			addBackupFlags(cmd)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatalf("parsing flags: %v", err)
+			}
+
+			err := applySettings(cmd.Flags(), tt.settings)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applySettings: %v", err)
+			}
+			for name, want := range tt.want {
+				if got := cmd.Flags().Lookup(name).Value.String(); got != want {
+					t.Errorf("%s = %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveInstances(t *testing.T) {
+	t.Setenv("VAULTAGE_INSTANCE", "")
+	t.Setenv("VAULTAGE_OUTPUT_DIR", "")
+
+	const config = `
+output-dir: /backups
+spool-max-size: 10
+instances:
+  family:
+    data-dir: /srv/family
+  work:
+    data-dir: /srv/work
+    output-dir: /backups/work
+    destination: [/a, /b]
+`
+	tests := []struct {
+		name string
+		args []string
+		// Instance names and their output dirs
+		want map[string]string
+		err  string
+	}{
+		{
+			name: "instance settings override top-level ones",
+			want: map[string]string{"family": "/backups", "work": "/backups/work"},
+		},
+		{
+			name: "flags override instance settings",
+			args: []string{"--output-dir", "/cli"},
+			want: map[string]string{"family": "/cli", "work": "/cli"},
+		},
+		{
+			name: "selected instance",
+			args: []string{"--instance", "work"},
+			want: map[string]string{"work": "/backups/work"},
+		},
+		{name: "unknown instance", args: []string{"--instance", "home"}, err: `instance "home" is not in`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := backupCmdWithConfig(t, config, tt.args...)
+			instances, err := resolveInstances(cmd, addBackupCommandFlags)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveInstances: %v", err)
+			}
+
+			got := map[string]string{}
+			for _, inst := range instances {
+				got[inst.name], _ = inst.cmd.Flags().GetString("output-dir")
+				if size, _ := inst.cmd.Flags().GetInt64("spool-max-size"); size != 10 {
+					t.Errorf("instance %s: spool-max-size = %d, want 10 from the top level", inst.name, size)
+				}
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("instances = %v, want %v", got, tt.want)
+			}
+
+			// Lists are set per instance
+			for _, inst := range instances {
+				dests, _ := inst.cmd.Flags().GetStringArray("destination")
+				if want := map[string]int{"family": 0, "work": 2}[inst.name]; len(dests) != want {
+					t.Errorf("instance %s: destinations = %v, want %d", inst.name, dests, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveInstances_WithoutInstances(t *testing.T) {
+	t.Setenv("VAULTAGE_INSTANCE", "")
+
+	cmd := backupCmdWithConfig(t, "output-dir: /backups\n")
+	instances, err := resolveInstances(cmd, addBackupCommandFlags)
+	if err != nil || len(instances) != 1 || instances[0].name != "" {
+		t.Fatalf("resolveInstances = %v, %v, want a single unnamed instance", instances, err)
+	}
+	if dir, _ := instances[0].cmd.Flags().GetString("output-dir"); dir != "/backups" {
+		t.Fatalf("output-dir = %q, want /backups", dir)
+	}
+
+	cmd = backupCmdWithConfig(t, "output-dir: /backups\n", "--instance", "family")
+	if _, err := resolveInstances(cmd, addBackupCommandFlags); err == nil || !strings.Contains(err.Error(), "requires a config file with instances") {
+		t.Fatalf("expected error for --instance without instances, got %v", err)
+	}
+}
+
+func TestLoadConfigFile_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		yaml string
+		err  string
+	}{
+		"unknown setting":     {"output-directory: /backups\n", `unknown setting "output-directory"`},
+		"config in the file":  {"config: other.yaml\n", "config cannot be set in the config file"},
+		"process setting":     {"instances:\n  family:\n    log-level: debug\n", `"log-level" can only be set at the top level, not for instance "family"`},
+		"unknown in instance": {"instances:\n  family:\n    data-directory: /srv\n", `unknown setting "data-directory"`},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cmd := backupCmdWithConfig(t, tt.yaml)
+			if _, err := loadConfigFile(cmd); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// Settings of other commands are accepted, so one file serves all of them
+	cmd := backupCmdWithConfig(t, "listen: :8080\nlog-level: debug\n")
+	if _, err := loadConfigFile(cmd); err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+}
+
+func TestCheckInstanceConflicts(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	config := func(instance, dataDir, stateDir string, dests ...string) backup.Config {
+		cfg := backup.Config{Instance: instance, DataDir: dataDir, StateDir: stateDir}
+		for _, d := range dests {
+			cfg.Targets = append(cfg.Targets, backup.Target{Destination: destination.NewLocal(d)})
+		}
+		return cfg
+	}
+
+	tests := []struct {
+		name string
+		cfgs []backup.Config
+		err  string
+	}{
+		{
+			name: "separate",
+			cfgs: []backup.Config{
+				config("family", "/srv/family", "/state/family", "/backups/family"),
+				config("work", "/srv/work", "/state/work", "/backups/work"),
+			},
+		},
+		{
+			name: "same data directory",
+			cfgs: []backup.Config{
+				config("family", "/srv/vw", "/state/family", "/backups/family"),
+				config("work", "/srv/vw", "/state/work", "/backups/work"),
+			},
+			err: "instances family and work use the same data directory /srv/vw",
+		},
+		{
+			name: "same state directory",
+			cfgs: []backup.Config{
+				config("family", "/srv/family", "/state", "/backups/family"),
+				config("work", "/srv/work", "/state", "/backups/work"),
+			},
+			err: "same state directory /state",
+		},
+		{
+			// Relative paths are compared as absolute ones
+			name: "same destination",
+			cfgs: []backup.Config{
+				config("family", "/srv/family", "/state/family", "backups"),
+				config("work", "/srv/work", "/state/work", filepath.Join(dir, "backups")),
+			},
+			err: "same destination " + filepath.Join(dir, "backups"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkInstanceConflicts(tt.cfgs)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("checkInstanceConflicts: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestConfigCheck_KeyFile(t *testing.T) {
+	t.Setenv("VAULTAGE_INSTANCE", "")
+	t.Setenv("VAULTAGE_AGE_PASSPHRASE", "")
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generating identity: %v", err)
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// Same verdicts as doctor, see doctor.CheckKeyFile
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"identity", write("key.txt", identity.String()+"\n"), "  ok    age key file: "},
+		{"recipient only", write("recipient.txt", identity.Recipient().String()+"\n"), "  ok    age key file: "},
+		{"garbage", write("garbage.txt", "not a key\n"), "  fail  age key file: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := RootCmd(context.Background())
+			out := &strings.Builder{}
+			root.SetOut(out)
+			root.SetErr(io.Discard)
+			root.SetArgs([]string{"config", "check", t.TempDir(), "--output-dir", t.TempDir(), "--age-key-file", tt.path})
+			err := root.Execute()
+			if !strings.Contains(out.String(), tt.want) {
+				t.Fatalf("output does not contain %q:\n%s", tt.want, out)
+			}
+			if (err != nil) != strings.Contains(tt.want, "fail") {
+				t.Fatalf("config check error = %v", err)
+			}
+		})
+	}
+}
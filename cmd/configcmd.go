@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/doctor"
+)
+
+// Time limit for listing a destination in config check.
+const destinationCheckTimeout = 30 * time.Second
+
+// Creates a Cobra command that shows and validates the effective settings.
+func Config(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "Show and validate the effective configuration",
+		Use:   "config",
+	}
+	cmd.AddCommand(configShow())
+	cmd.AddCommand(configCheck(ctx))
+	return cmd
+}
+
+// Creates the config show command, which prints the merged settings of
+// flags, env vars and the config file with secrets redacted.
+func configShow() *cobra.Command {
+	cmd := &cobra.Command{
+		Short:        "Print the effective settings and where they come from",
+		Use:          "show [data dir]",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			instances, err := resolveInstances(cmd, addWatchFlags)
+			if err != nil {
+				return err
+			}
+			file, err := loadConfigFile(cmd)
+			if err != nil {
+				return err
+			}
+			var global settings
+			if file != nil {
+				global = file.global
+			}
+
+			named := instances[0].name != ""
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			if named {
+				fmt.Fprint(tw, "INSTANCE\t")
+			}
+			fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+			row := func(instance, setting, value, source string) {
+				if named {
+					fmt.Fprintf(tw, "%s\t", instance)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", setting, orDash(value), source)
+			}
+
+			// Process-wide settings are shared by all instances
+			cmd.InheritedFlags().VisitAll(func(f *pflag.Flag) {
+				value, source := settingValue(f, global)
+				row("*", f.Name, redactSetting(flagEnvVar(f), value), source)
+			})
+
+			for _, inst := range instances {
+				inst.cmd.Flags().VisitAll(func(f *pflag.Flag) {
+					value, source := settingValue(f, inst.settings)
+					if f.Name == "data-dir" && len(args) > 0 {
+						value, source = args[0], "argument"
+					}
+					row(inst.name, f.Name, redactSetting(flagEnvVar(f), value), source)
+				})
+				for _, name := range extraEnvVars {
+					if value := os.Getenv(name); value != "" {
+						row(inst.name, name, redactSetting(name, value), sourceEnv)
+					}
+				}
+			}
+			return tw.Flush()
+		},
+	}
+
+	addWatchFlags(cmd)
+
+	return cmd
+}
+
+// Creates the config check command, which validates the settings of every
+// instance, including key files and access to the destinations.
+func configCheck(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short:        "Validate the configuration and access to the destinations",
+		Use:          "check [data dir]",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Env vars and the config file were validated before running
+			instances, err := resolveInstances(cmd, addWatchFlags)
+			if err != nil {
+				return err
+			}
+			if len(instances) > 1 && len(args) > 0 {
+				return fmt.Errorf("a data directory argument cannot be used with several instances, set data-dir in the config file")
+			}
+
+			w := cmd.OutOrStdout()
+			var errs []error
+			var cfgs []backup.Config
+			for _, inst := range instances {
+				if inst.name != "" {
+					fmt.Fprintf(w, "instance %s\n", inst.name)
+				}
+				cfg, err := checkInstance(ctx, w, inst, args)
+				if err != nil {
+					errs = append(errs, inst.wrap(err))
+					continue
+				}
+				cfgs = append(cfgs, cfg)
+			}
+			if err := checkInstanceConflicts(cfgs); err != nil {
+				errs = append(errs, err)
+			}
+
+			if err := errors.Join(errs...); err != nil {
+				return err
+			}
+			fmt.Fprintln(w, "configuration is valid")
+			return nil
+		},
+	}
+
+	addWatchFlags(cmd)
+
+	return cmd
+}
+
+// Validates the settings of one instance, reporting each check to w.
+// Settings that prevent resolving the instance fail it right away, the
+// other checks all run and their errors are joined.
+func checkInstance(ctx context.Context, w io.Writer, inst instance, args []string) (backup.Config, error) {
+	cfg, err := resolveInstanceConfig(ctx, inst, args)
+	if err != nil {
+		return backup.Config{}, err
+	}
+	fmt.Fprintf(w, "  ok    data directory %s\n", cfg.DataDir)
+
+	var errs []error
+	fail := func(what string, err error) {
+		fmt.Fprintf(w, "  fail  %s: %v\n", what, err)
+		errs = append(errs, fmt.Errorf("%s: %w", what, err))
+	}
+
+	if _, _, err := resolveNotifyFlags(inst.cmd, cfg.DataDir); err != nil {
+		fail("notifications", err)
+	} else {
+		fmt.Fprintln(w, "  ok    notifications")
+	}
+
+	switch {
+	case cfg.WithoutEncryption:
+		fmt.Fprintln(w, "  warn  encryption is disabled")
+	case cfg.AgePassphrase != "" && cfg.AgeKeyFile != "":
+		fail("encryption", errors.New("--age-passphrase and --age-key-file are mutually exclusive"))
+	case cfg.AgeKeyFile != "":
+		if level, detail := doctor.CheckKeyFile(cfg.AgeKeyFile); level == doctor.Fail {
+			fail("age key file", errors.New(detail))
+		} else {
+			fmt.Fprintf(w, "  ok    age key file: %s\n", detail)
+		}
+	case cfg.AgePassphrase != "":
+		fmt.Fprintln(w, "  ok    age passphrase")
+	default:
+		fmt.Fprintln(w, "  warn  no age passphrase or key file, backup will prompt and watch will not start")
+	}
+
+	for _, t := range cfg.OutputTargets() {
+		err := checkDestination(ctx, t)
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+			fmt.Fprintf(w, "  skip  destination %s cannot be listed\n", t.Destination)
+		case err != nil:
+			fail("destination "+t.Destination.String(), err)
+		default:
+			fmt.Fprintf(w, "  ok    destination %s\n", t.Destination)
+		}
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// Checks that the destination can be reached by listing it.
+func checkDestination(ctx context.Context, t backup.Target) error {
+	ctx, cancel := context.WithTimeout(ctx, destinationCheckTimeout)
+	defer cancel()
+	_, err := t.Destination.List(ctx)
+	return err
+}
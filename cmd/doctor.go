@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/doctor"
+	"github.com/mijolabs/vaultage/history"
+)
+
+// Creates a Cobra command that diagnoses the data directory, the output
+// directory, the encryption settings and the clock.
+func Doctor(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short:        "Diagnose the environment backups run in",
+		Use:          "doctor [data dir]",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			instances, err := resolveInstances(cmd, addBackupFlags)
+			if err != nil {
+				return err
+			}
+			if len(instances) > 1 && len(args) > 0 {
+				return fmt.Errorf("a data directory argument cannot be used with several instances, set data-dir in the config file")
+			}
+
+			w := cmd.OutOrStdout()
+			var failed, warnings int
+			for _, inst := range instances {
+				cfg, err := resolveDoctorConfig(ctx, inst, args)
+				if err != nil {
+					return inst.wrap(err)
+				}
+				report := doctor.Run(cfg)
+				failed += report.Count(doctor.Fail)
+				warnings += report.Count(doctor.Warn)
+
+				if inst.name != "" {
+					fmt.Fprintf(w, "instance %s\n", inst.name)
+				}
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				for _, c := range report.Checks {
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(string(c.Level)), c.Name, c.Detail)
+				}
+				if err := tw.Flush(); err != nil {
+					return err
+				}
+			}
+
+			fmt.Fprintf(w, "\n%d failed, %d warnings\n", failed, warnings)
+			if failed > 0 {
+				return fmt.Errorf("%d checks failed", failed)
+			}
+			return nil
+		},
+	}
+
+	addBackupFlags(cmd)
+
+	return cmd
+}
+
+// Reads the backup settings of one instance like resolveInstanceConfig,
+// but leaves checking the data and output directories to the doctor and
+// does not open the spool or run history.
+func resolveDoctorConfig(ctx context.Context, inst instance, args []string) (backup.Config, error) {
+	dataDir, err := dataDirSetting(inst.cmd, args)
+	if err != nil {
+		return backup.Config{}, err
+	}
+
+	cfg, err := resolveBackupSettings(inst.cmd)
+	if err != nil {
+		return backup.Config{}, err
+	}
+	cfg.DataDir = dataDir
+	cfg.Instance = inst.name
+
+	// The clock check reads the run history, which creates nothing
+	if stateDir, err := resolveStateDir(inst.cmd, resolveDestinationURLs(inst.cmd, cfg.OutputDir)); err == nil {
+		cfg.StateDir = stateDir
+		cfg.History = history.Open(stateDir)
+	}
+
+	if cfg.Snapshot, err = backup.ParseSnapshotStrategy(string(cfg.Snapshot)); err != nil {
+		return backup.Config{}, err
+	}
+	if err := resolvePassphraseCommand(ctx, inst.cmd, &cfg); err != nil {
+		return backup.Config{}, err
+	}
+	return cfg, nil
+}
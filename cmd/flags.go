@@ -1,31 +1,86 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 
 	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/destination"
+	"github.com/mijolabs/vaultage/history"
+	"github.com/mijolabs/vaultage/retention"
+	"github.com/mijolabs/vaultage/spool"
 )
 
 // Registers shared backup settings flags on a command.
 func addBackupFlags(cmd *cobra.Command) {
+	cmd.Flags().String("data-dir", "", "path to the Vaultwarden data directory, instead of the argument (env: VAULTAGE_DATA_DIR)")
 	cmd.Flags().String("output-dir", ".", "directory for backup files (env: VAULTAGE_OUTPUT_DIR)")
+	cmd.Flags().StringArray("destination", nil, "destination URL for backup files, repeatable, overrides --output-dir (env: VAULTAGE_DESTINATION, space-separated)")
+	cmd.Flags().String("state-dir", "", "directory for state such as the upload spool and run history, defaults to .vaultage in the first local destination and is required without one (env: VAULTAGE_STATE_DIR)")
+	cmd.Flags().Int64("spool-max-size", 1024, "maximum size in MiB of archives queued for unreachable destinations, 0 disables the spool (env: VAULTAGE_SPOOL_MAX_SIZE)")
 	cmd.Flags().Bool("exclude-attachments", false, "exclude attachments in backup archive (env: VAULTAGE_EXCLUDE_ATTACHMENTS)")
 	cmd.Flags().Bool("exclude-config-file", false, "exclude config.json in backup archive (env: VAULTAGE_EXCLUDE_CONFIG_FILE)")
 	cmd.Flags().Bool("without-encryption", false, "disable encryption for backups (env: VAULTAGE_WITHOUT_ENCRYPTION)")
 	cmd.Flags().String("age-passphrase", "", "age passphrase for backup encryption (env: VAULTAGE_AGE_PASSPHRASE)")
+	cmd.Flags().String("age-passphrase-command", "", "shell command printing the age passphrase, such as \"pass show vaultage\" (env: VAULTAGE_AGE_PASSPHRASE_COMMAND)")
 	cmd.Flags().String("age-key-file", "", "age key file for backup encryption (env: VAULTAGE_AGE_KEY_FILE)")
+	cmd.Flags().String("snapshot-mode", "auto", "database snapshot mode: auto, online or copy (env: VAULTAGE_SNAPSHOT_MODE)")
+	cmd.Flags().String("filename-template", backup.DefaultFilenameTemplate, "Go template naming the archives, may contain slashes for subdirectories (env: VAULTAGE_FILENAME_TEMPLATE)")
 }
 
 // Reads the shared backup flags, applying env var fallbacks when a flag
-// was not explicitly set on the command line.
-func resolveBackupFlags(cmd *cobra.Command) backup.Config {
+// was not explicitly set on the command line, and opens the spool and
+// run history in the state directory.
+func resolveBackupFlags(cmd *cobra.Command) (backup.Config, error) {
+	cfg, err := resolveBackupSettings(cmd)
+	if err != nil {
+		return backup.Config{}, err
+	}
+	if err := openState(cmd, &cfg); err != nil {
+		return backup.Config{}, err
+	}
+	return cfg, nil
+}
+
+// Resolves the state directory and opens the spool and run history in it.
+func openState(cmd *cobra.Command, cfg *backup.Config) error {
+	stateDir, err := resolveStateDir(cmd, resolveDestinationURLs(cmd, cfg.OutputDir))
+	if err != nil {
+		return err
+	}
+
+	spoolMaxSize, _ := cmd.Flags().GetInt64("spool-max-size")
+	if !cmd.Flags().Changed("spool-max-size") {
+		spoolMaxSize = envInt64OrDefault("VAULTAGE_SPOOL_MAX_SIZE", spoolMaxSize)
+	}
+	if spoolMaxSize > 0 && hasRemoteTarget(cfg.Targets) {
+		cfg.Spool, err = spool.Open(filepath.Join(stateDir, "spool"), spoolMaxSize<<20)
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg.StateDir = stateDir
+	cfg.History = history.Open(stateDir)
+	return nil
+}
+
+// Reads the shared backup flags like resolveBackupFlags, without touching
+// the state directory.
+func resolveBackupSettings(cmd *cobra.Command) (backup.Config, error) {
 	outputDir, _ := cmd.Flags().GetString("output-dir")
 	if !cmd.Flags().Changed("output-dir") {
 		outputDir = envStringOrDefault("VAULTAGE_OUTPUT_DIR", outputDir)
 	}
 
+	targets, err := openTargets(resolveDestinationURLs(cmd, outputDir))
+	if err != nil {
+		return backup.Config{}, err
+	}
+
 	excludeAttachments, _ := cmd.Flags().GetBool("exclude-attachments")
 	if !cmd.Flags().Changed("exclude-attachments") {
 		excludeAttachments = envBoolOrDefault("VAULTAGE_EXCLUDE_ATTACHMENTS", excludeAttachments)
@@ -42,13 +97,27 @@ func resolveBackupFlags(cmd *cobra.Command) backup.Config {
 	}
 
 	agePassphrase, _ := cmd.Flags().GetString("age-passphrase")
-	if agePassphrase == "" {
-		agePassphrase = os.Getenv("VAULTAGE_AGE_PASSPHRASE")
+	if !cmd.Flags().Changed("age-passphrase") {
+		agePassphrase = envStringOrDefault("VAULTAGE_AGE_PASSPHRASE", agePassphrase)
 	}
 
 	ageKeyFile, _ := cmd.Flags().GetString("age-key-file")
-	if ageKeyFile == "" {
-		ageKeyFile = os.Getenv("VAULTAGE_AGE_KEY_FILE")
+	if !cmd.Flags().Changed("age-key-file") {
+		ageKeyFile = envStringOrDefault("VAULTAGE_AGE_KEY_FILE", ageKeyFile)
+	}
+
+	snapshotMode, _ := cmd.Flags().GetString("snapshot-mode")
+	if !cmd.Flags().Changed("snapshot-mode") {
+		snapshotMode = envStringOrDefault("VAULTAGE_SNAPSHOT_MODE", snapshotMode)
+	}
+
+	filenameTemplate, _ := cmd.Flags().GetString("filename-template")
+	if !cmd.Flags().Changed("filename-template") {
+		filenameTemplate = envStringOrDefault("VAULTAGE_FILENAME_TEMPLATE", filenameTemplate)
+	}
+	names, err := backup.ParseFilenameTemplate(filenameTemplate)
+	if err != nil {
+		return backup.Config{}, err
 	}
 
 	return backup.Config{
@@ -58,5 +127,111 @@ func resolveBackupFlags(cmd *cobra.Command) backup.Config {
 		WithoutEncryption:  withoutEncryption,
 		AgePassphrase:      agePassphrase,
 		AgeKeyFile:         ageKeyFile,
+		Snapshot:           backup.SnapshotStrategy(snapshotMode),
+		Targets:            targets,
+		FilenameTemplate:   names,
+	}, nil
+}
+
+// Reads the destination flag, defaulting to outputDir.
+func resolveDestinationURLs(cmd *cobra.Command, outputDir string) []string {
+	destinationURLs, _ := cmd.Flags().GetStringArray("destination")
+	if !cmd.Flags().Changed("destination") {
+		destinationURLs = envFieldsOrDefault("VAULTAGE_DESTINATION", destinationURLs)
+	}
+	if len(destinationURLs) == 0 {
+		return []string{outputDir}
+	}
+	return destinationURLs
+}
+
+// Reads the output-dir, destination and state-dir flags and returns the
+// state directory, without opening any destinations.
+func resolveStateDirOnly(cmd *cobra.Command) (string, error) {
+	outputDir, _ := cmd.Flags().GetString("output-dir")
+	if !cmd.Flags().Changed("output-dir") {
+		outputDir = envStringOrDefault("VAULTAGE_OUTPUT_DIR", outputDir)
+	}
+	return resolveStateDir(cmd, resolveDestinationURLs(cmd, outputDir))
+}
+
+// Reads the state-dir flag, defaulting to .vaultage in the first local
+// destination. Without a local destination the flag is required, rather
+// than keeping state in whatever the working directory happens to be.
+func resolveStateDir(cmd *cobra.Command, destinationURLs []string) (string, error) {
+	stateDir, _ := cmd.Flags().GetString("state-dir")
+	if !cmd.Flags().Changed("state-dir") {
+		stateDir = envStringOrDefault("VAULTAGE_STATE_DIR", stateDir)
+	}
+	if stateDir != "" {
+		return stateDir, nil
+	}
+
+	for _, rawURL := range destinationURLs {
+		destURL, _, err := retention.SplitURL(rawURL)
+		if err != nil {
+			continue
+		}
+		if dir, ok := destination.LocalPath(destURL); ok {
+			return filepath.Join(dir, ".vaultage"), nil
+		}
+	}
+	return "", fmt.Errorf("no local destination to keep the upload spool and run history in, set --state-dir or VAULTAGE_STATE_DIR")
+}
+
+// Opens the destinations and splits off their retention policies.
+func openTargets(rawURLs []string) ([]backup.Target, error) {
+	opts := resolveDestinationOptions()
+
+	targets := make([]backup.Target, 0, len(rawURLs))
+	for _, rawURL := range rawURLs {
+		destURL, policy, err := retention.SplitURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", destination.Redact(rawURL), err)
+		}
+		dest, err := destination.Open(destURL, opts)
+		if err != nil {
+			return nil, fmt.Errorf("opening destination: %w", err)
+		}
+		if _, ok := dest.(*destination.Exec); ok && !policy.IsZero() {
+			return nil, fmt.Errorf("destination %s: retention is not supported for exec destinations", dest)
+		}
+		targets = append(targets, backup.Target{Destination: dest, Retention: policy})
+	}
+
+	return targets, nil
+}
+
+// Reports whether any target is not a local directory. Spooling archives
+// for local targets would only fill up the same disk.
+func hasRemoteTarget(targets []backup.Target) bool {
+	for _, t := range targets {
+		if _, ok := t.Destination.(*destination.Local); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads destination credentials, which are only taken from env vars
+// so they do not show up in process listings.
+func resolveDestinationOptions() destination.Options {
+	return destination.Options{
+		S3: destination.S3Credentials{
+			AccessKeyID:     os.Getenv("VAULTAGE_S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("VAULTAGE_S3_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("VAULTAGE_S3_SESSION_TOKEN"),
+		},
+		SFTP: destination.SFTPCredentials{
+			KeyFile:       os.Getenv("VAULTAGE_SFTP_KEY_FILE"),
+			KeyPassphrase: os.Getenv("VAULTAGE_SFTP_KEY_PASSPHRASE"),
+		},
+		WebDAV: destination.WebDAVCredentials{
+			Password:    os.Getenv("VAULTAGE_WEBDAV_PASSWORD"),
+			BearerToken: os.Getenv("VAULTAGE_WEBDAV_TOKEN"),
+		},
+		Exec: destination.ExecOptions{
+			HiddenEnv: secretEnvNames(),
+		},
 	}
 }
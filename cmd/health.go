@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mijolabs/vaultage/watcher"
+)
+
+// Name of the file in the state dir the watcher keeps its status in.
+const statusFileName = "status.json"
+
+// healthHandler serves the watcher status on /healthz. It answers 503
+// when the watcher is unhealthy, see watcher.Status.Check. The optional
+// max-age query parameter limits the age of the newest successful backup.
+// With several instances, it answers 503 if any of them is unhealthy.
+type healthHandler struct {
+	names    []string
+	statuses []atomic.Pointer[watcher.Status]
+}
+
+// Body of /healthz responses.
+type healthResponse struct {
+	watcher.Status
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Body of /healthz responses with several instances.
+type instancesHealthResponse struct {
+	Healthy   bool                      `json:"healthy"`
+	Error     string                    `json:"error,omitempty"`
+	Instances map[string]healthResponse `json:"instances"`
+}
+
+// Returns a handler for the named instances, a single unnamed one
+// without a config file.
+func newHealthHandler(names []string) *healthHandler {
+	return &healthHandler{names: names, statuses: make([]atomic.Pointer[watcher.Status], len(names))}
+}
+
+// Returns the function receiving the status of the i-th instance.
+func (h *healthHandler) updater(i int) func(watcher.Status) {
+	return func(s watcher.Status) {
+		h.statuses[i].Store(&s)
+	}
+}
+
+// Returns the health of the i-th instance.
+func (h *healthHandler) check(i int, maxAge time.Duration) healthResponse {
+	var resp healthResponse
+	if s := h.statuses[i].Load(); s != nil {
+		resp.Status = *s
+	}
+	if err := resp.Check(time.Now(), maxAge); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Healthy = true
+	}
+	return resp
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var maxAge time.Duration
+	if v := r.URL.Query().Get("max-age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid max-age %q", v), http.StatusBadRequest)
+			return
+		}
+		maxAge = d
+	}
+
+	var resp any
+	var healthy bool
+	if len(h.names) == 1 && h.names[0] == "" {
+		single := h.check(0, maxAge)
+		resp, healthy = single, single.Healthy
+	} else {
+		multi := instancesHealthResponse{Healthy: true, Instances: map[string]healthResponse{}}
+		var errs []string
+		for i, name := range h.names {
+			inst := h.check(i, maxAge)
+			multi.Instances[name] = inst
+			if !inst.Healthy {
+				multi.Healthy = false
+				errs = append(errs, name+": "+inst.Error)
+			}
+		}
+		multi.Error = strings.Join(errs, "; ")
+		resp, healthy = multi, multi.Healthy
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mijolabs/vaultage/watcher"
+)
+
+// Creates a Cobra command that checks the health of a running watcher,
+// for use as a container HEALTHCHECK. It exits non-zero when unhealthy.
+func Healthcheck(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short:        "Check the health of a running watcher",
+		Use:          "healthcheck",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			healthURL, _ := cmd.Flags().GetString("url")
+			if !cmd.Flags().Changed("url") {
+				healthURL = envStringOrDefault("VAULTAGE_HEALTHCHECK_URL", healthURL)
+			}
+
+			maxAge, _ := cmd.Flags().GetDuration("max-age")
+			if !cmd.Flags().Changed("max-age") {
+				maxAge = envDurationOrDefault("VAULTAGE_HEALTHCHECK_MAX_AGE", maxAge)
+			}
+
+			var err error
+			if healthURL != "" {
+				err = checkHealthURL(ctx, healthURL, maxAge)
+			} else {
+				err = checkStatusFiles(cmd, maxAge)
+			}
+			if err != nil {
+				return fmt.Errorf("unhealthy: %w", err)
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), "healthy")
+			return nil
+		},
+	}
+
+	cmd.Flags().String("url", "", "health endpoint of the watcher, e.g. http://127.0.0.1:9090/healthz, instead of the status file (env: VAULTAGE_HEALTHCHECK_URL)")
+	cmd.Flags().String("state-dir", "", "state directory of the watcher, defaults to .vaultage in the output dir (env: VAULTAGE_STATE_DIR)")
+	cmd.Flags().Duration("max-age", 0, "maximum age of the newest successful backup, 0 disables the check (env: VAULTAGE_HEALTHCHECK_MAX_AGE)")
+
+	return cmd
+}
+
+// Evaluates the status files of all instances.
+func checkStatusFiles(cmd *cobra.Command, maxAge time.Duration) error {
+	instances, err := resolveInstances(cmd, addBackupFlags)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, inst := range instances {
+		stateDir, err := resolveStateDirOnly(inst.cmd)
+		if err != nil {
+			errs = append(errs, inst.wrap(err))
+			continue
+		}
+		if err := checkStatusFile(filepath.Join(stateDir, statusFileName), maxAge); err != nil {
+			errs = append(errs, inst.wrap(err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Evaluates the status file written by the watcher.
+func checkStatusFile(path string, maxAge time.Duration) error {
+	s, err := watcher.ReadStatusFile(path)
+	if err != nil {
+		return err
+	}
+	return s.Check(time.Now(), maxAge)
+}
+
+// Queries the watcher's health endpoint.
+func checkHealthURL(ctx context.Context, rawURL string, maxAge time.Duration) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid health URL: %w", err)
+	}
+	if maxAge > 0 {
+		q := u.Query()
+		q.Set("max-age", maxAge.String())
+		u.RawQuery = q.Encode()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body healthResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if body.Error != "" {
+			return errors.New(body.Error)
+		}
+		return fmt.Errorf("health endpoint answered %s", resp.Status)
+	}
+	return nil
+}
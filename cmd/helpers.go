@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/spf13/cobra"
 )
 
 var boolMap = map[string]bool{
@@ -18,6 +21,7 @@ var boolMap = map[string]bool{
 }
 
 // Returns the value of the environment variable as a bool, or the default.
+// Invalid values are rejected by checkEnvVars before commands run.
 func envBoolOrDefault(key string, defaultVal bool) bool {
 	val := os.Getenv(key)
 	if val == "" {
@@ -37,7 +41,39 @@ func envStringOrDefault(key, defaultVal string) string {
 	return defaultVal
 }
 
+// Returns the space-separated values of the environment variable, or the default.
+func envFieldsOrDefault(key string, defaultVal []string) []string {
+	if val := os.Getenv(key); val != "" {
+		return strings.Fields(val)
+	}
+	return defaultVal
+}
+
+// Returns the non-empty lines of the environment variable, or the default.
+func envLinesOrDefault(key string, defaultVal []string) []string {
+	if val := os.Getenv(key); val != "" {
+		return nonEmptyLines(val)
+	}
+	return defaultVal
+}
+
+// Returns the value of the environment variable as an int64, or the default.
+// Invalid values are rejected by checkEnvVars before commands run.
+func envInt64OrDefault(key string, defaultVal int64) int64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultVal
+	}
+
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return defaultVal
+	}
+	return n
+}
+
 // Returns the value of the environment variable as a duration, or the default.
+// Invalid values are rejected by checkEnvVars before commands run.
 func envDurationOrDefault(key string, defaultVal time.Duration) time.Duration {
 	val := os.Getenv(key)
 	if val == "" {
@@ -51,13 +87,13 @@ func envDurationOrDefault(key string, defaultVal time.Duration) time.Duration {
 	return d
 }
 
-// Validates the data directory path from command-line arguments.
-func validateDataDirFromArgs(args []string) (string, error) {
-	// Require data dir path provided in args
-	if len(args) < 1 {
-		return "", fmt.Errorf("missing path to vaultwarden data directory")
+// Reads the data directory from the command-line arguments, --data-dir
+// or VAULTAGE_DATA_DIR, and validates it.
+func resolveDataDir(cmd *cobra.Command, args []string) (string, error) {
+	dataDir, err := dataDirSetting(cmd, args)
+	if err != nil {
+		return "", err
 	}
-	dataDir := strings.TrimSuffix(args[0], "/")
 
 	// Validate data dir path
 	info, err := os.Stat(dataDir)
@@ -73,3 +109,20 @@ func validateDataDirFromArgs(args []string) (string, error) {
 
 	return dataDir, nil
 }
+
+// Reads the data directory from the command-line arguments, --data-dir
+// or VAULTAGE_DATA_DIR, without checking that it exists.
+func dataDirSetting(cmd *cobra.Command, args []string) (string, error) {
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	if len(args) > 0 {
+		dataDir = args[0]
+	} else if !cmd.Flags().Changed("data-dir") {
+		dataDir = envStringOrDefault("VAULTAGE_DATA_DIR", dataDir)
+	}
+
+	// Require data dir path
+	if dataDir == "" {
+		return "", fmt.Errorf("missing path to vaultwarden data directory")
+	}
+	return strings.TrimSuffix(dataDir, "/"), nil
+}
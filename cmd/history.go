@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mijolabs/vaultage/history"
+	"github.com/mijolabs/vaultage/logging"
+)
+
+// Creates a Cobra command that shows the journal of past backup runs.
+func History(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Short:        "Show the history of backup runs",
+		Use:          "history",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			limit, _ := cmd.Flags().GetInt("limit")
+			asJSON, _ := cmd.Flags().GetBool("json")
+
+			instances, err := resolveInstances(cmd, addBackupFlags)
+			if err != nil {
+				return err
+			}
+
+			var records []history.Record
+			var dirs []string
+			for _, inst := range instances {
+				stateDir, err := resolveStateDirOnly(inst.cmd)
+				if err != nil {
+					return inst.wrap(err)
+				}
+				journal := history.Open(stateDir)
+				instRecords, err := journal.Records()
+				if err != nil {
+					return inst.wrap(err)
+				}
+				for _, r := range instRecords {
+					if r.Instance == "" {
+						r.Instance = inst.name
+					}
+					records = append(records, r)
+				}
+				dirs = append(dirs, filepath.Dir(journal.Path()))
+			}
+			slices.SortStableFunc(records, func(a, b history.Record) int {
+				return a.Started.Compare(b.Started)
+			})
+			if limit > 0 && len(records) > limit {
+				records = records[len(records)-limit:]
+			}
+
+			w := cmd.OutOrStdout()
+			if asJSON {
+				enc := json.NewEncoder(w)
+				for _, r := range records {
+					if err := enc.Encode(r); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			if len(records) == 0 {
+				fmt.Fprintf(w, "no backup runs recorded in %s\n", strings.Join(dirs, ", "))
+				return nil
+			}
+
+			named := instances[0].name != ""
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			if named {
+				fmt.Fprint(tw, "INSTANCE\t")
+			}
+			fmt.Fprintln(tw, "STARTED\tTRIGGER\tRESULT\tDURATION\tSIZE\tARCHIVE\tERROR")
+			for _, r := range records {
+				size := "-"
+				if r.Size > 0 {
+					size = logging.FormatSize(r.Size)
+				}
+				if named {
+					fmt.Fprintf(tw, "%s\t", r.Instance)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					r.Started.Local().Format(time.DateTime),
+					orDash(string(r.Trigger)),
+					r.Result,
+					r.Finished.Sub(r.Started).Round(time.Millisecond),
+					size,
+					orDash(r.Archive),
+					orDash(historyError(r)),
+				)
+			}
+			return tw.Flush()
+		},
+	}
+
+	cmd.Flags().String("state-dir", "", "state directory holding the history, defaults to .vaultage in the output dir (env: VAULTAGE_STATE_DIR)")
+	cmd.Flags().Int("limit", 20, "number of most recent runs to show, 0 shows all")
+	cmd.Flags().Bool("json", false, "print the records as JSON Lines")
+
+	return cmd
+}
+
+// Returns the error of a run, or the first destination error of a run
+// that succeeded with warnings, such as a spooled upload.
+func historyError(r history.Record) string {
+	if r.Error != "" {
+		return r.Error
+	}
+	for _, d := range r.Destinations {
+		if d.Error != "" {
+			return d.Name + ": " + d.Error
+		}
+	}
+	return ""
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mijolabs/vaultage/logging"
+	"github.com/mijolabs/vaultage/notify"
+	"github.com/mijolabs/vaultage/watcher"
+)
+
+// Registers notification flags on a command.
+func addNotifyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("notify", nil, "chat notification URL (ntfy, gotify, discord, slack or matrix), repeatable (env: VAULTAGE_NOTIFY, space-separated)")
+	cmd.Flags().String("notify-template", "", "path to a Go template file for chat notification messages (env: VAULTAGE_NOTIFY_TEMPLATE)")
+	cmd.Flags().StringArray("notify-webhook", nil, "URL to POST backup events to, repeatable (env: VAULTAGE_NOTIFY_WEBHOOK, space-separated)")
+	cmd.Flags().String("notify-webhook-secret", "", "secret for signing webhook bodies with HMAC-SHA256 (env: VAULTAGE_NOTIFY_WEBHOOK_SECRET)")
+	cmd.Flags().StringArray("notify-webhook-header", nil, `header added to webhook requests as "Name: value", repeatable (env: VAULTAGE_NOTIFY_WEBHOOK_HEADERS, newline-separated)`)
+	cmd.Flags().String("notify-webhook-template", "", "path to a Go template file for webhook bodies (env: VAULTAGE_NOTIFY_WEBHOOK_TEMPLATE)")
+	cmd.Flags().StringArray("notify-email", nil, "email address to notify about failed backups, repeatable (env: VAULTAGE_NOTIFY_EMAIL, space-separated)")
+	cmd.Flags().Duration("notify-email-digest", 0, "also email a digest of successful backups at most this often, 0 disables it (env: VAULTAGE_NOTIFY_EMAIL_DIGEST)")
+	cmd.Flags().Bool("smtp-from-vaultwarden", false, "use Vaultwarden's SMTP settings from config.json in the data dir and SMTP_* env vars (env: VAULTAGE_SMTP_FROM_VAULTWARDEN)")
+	cmd.Flags().String("smtp-host", "", "SMTP server host (env: VAULTAGE_SMTP_HOST)")
+	cmd.Flags().Int("smtp-port", 0, "SMTP server port, defaults to 587, 465 or 25 depending on --smtp-security (env: VAULTAGE_SMTP_PORT)")
+	cmd.Flags().String("smtp-security", "", "SMTP connection security: starttls, force_tls or off, defaults to starttls (env: VAULTAGE_SMTP_SECURITY)")
+	cmd.Flags().String("smtp-username", "", "SMTP username (env: VAULTAGE_SMTP_USERNAME)")
+	cmd.Flags().String("smtp-password", "", "SMTP password (env: VAULTAGE_SMTP_PASSWORD)")
+	cmd.Flags().String("smtp-from", "", "sender address of notification emails (env: VAULTAGE_SMTP_FROM)")
+	cmd.Flags().String("ping-url", "", "dead man's switch URL, pinged with /start, on success and with /fail (env: VAULTAGE_PING_URL)")
+}
+
+// Reads the notification flags, applying env var fallbacks, and returns
+// a dispatcher for the configured notifiers. The ping is also returned on
+// its own, for start and keepalive pings, and is nil when not configured.
+func resolveNotifyFlags(cmd *cobra.Command, dataDir string) (*notify.Dispatcher, *notify.Ping, error) {
+	webhookURLs, _ := cmd.Flags().GetStringArray("notify-webhook")
+	if !cmd.Flags().Changed("notify-webhook") {
+		webhookURLs = envFieldsOrDefault("VAULTAGE_NOTIFY_WEBHOOK", webhookURLs)
+	}
+
+	secret, _ := cmd.Flags().GetString("notify-webhook-secret")
+	if !cmd.Flags().Changed("notify-webhook-secret") {
+		secret = envStringOrDefault("VAULTAGE_NOTIFY_WEBHOOK_SECRET", secret)
+	}
+
+	headerLines, _ := cmd.Flags().GetStringArray("notify-webhook-header")
+	if !cmd.Flags().Changed("notify-webhook-header") {
+		headerLines = envLinesOrDefault("VAULTAGE_NOTIFY_WEBHOOK_HEADERS", headerLines)
+	}
+	header, err := parseHeaders(headerLines)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	templatePath, _ := cmd.Flags().GetString("notify-webhook-template")
+	if !cmd.Flags().Changed("notify-webhook-template") {
+		templatePath = envStringOrDefault("VAULTAGE_NOTIFY_WEBHOOK_TEMPLATE", templatePath)
+	}
+
+	opts := notify.WebhookOptions{Secret: secret, Header: header}
+	if opts.Template, err = loadTemplate(templatePath); err != nil {
+		return nil, nil, fmt.Errorf("webhook template: %w", err)
+	}
+
+	var notifiers []notify.Notifier
+	for _, rawURL := range webhookURLs {
+		w, err := notify.NewWebhook(rawURL, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifiers = append(notifiers, w)
+	}
+
+	chatURLs, _ := cmd.Flags().GetStringArray("notify")
+	if !cmd.Flags().Changed("notify") {
+		chatURLs = envFieldsOrDefault("VAULTAGE_NOTIFY", chatURLs)
+	}
+
+	chatTemplatePath, _ := cmd.Flags().GetString("notify-template")
+	if !cmd.Flags().Changed("notify-template") {
+		chatTemplatePath = envStringOrDefault("VAULTAGE_NOTIFY_TEMPLATE", chatTemplatePath)
+	}
+	chatTemplate, err := loadTemplate(chatTemplatePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("notification template: %w", err)
+	}
+
+	for _, rawURL := range chatURLs {
+		chat, err := notify.NewChat(rawURL, chatTemplate)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifiers = append(notifiers, chat)
+	}
+
+	emailTo, _ := cmd.Flags().GetStringArray("notify-email")
+	if !cmd.Flags().Changed("notify-email") {
+		emailTo = envFieldsOrDefault("VAULTAGE_NOTIFY_EMAIL", emailTo)
+	}
+	if len(emailTo) > 0 {
+		smtpCfg, err := resolveSMTPFlags(cmd, dataDir)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		digest, _ := cmd.Flags().GetDuration("notify-email-digest")
+		if !cmd.Flags().Changed("notify-email-digest") {
+			digest = envDurationOrDefault("VAULTAGE_NOTIFY_EMAIL_DIGEST", digest)
+		}
+
+		email, err := notify.NewEmail(smtpCfg, emailTo, digest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring email notifications: %w", err)
+		}
+		notifiers = append(notifiers, email)
+	}
+
+	pingURL, _ := cmd.Flags().GetString("ping-url")
+	if !cmd.Flags().Changed("ping-url") {
+		pingURL = envStringOrDefault("VAULTAGE_PING_URL", pingURL)
+	}
+
+	var ping *notify.Ping
+	if pingURL != "" {
+		ping, err = notify.NewPing(pingURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifiers = append(notifiers, ping)
+	}
+
+	return notify.NewDispatcher(notifiers...), ping, nil
+}
+
+// Reads the SMTP flags. With --smtp-from-vaultwarden, Vaultwarden's own
+// settings are the defaults that the other flags override.
+func resolveSMTPFlags(cmd *cobra.Command, dataDir string) (notify.SMTPConfig, error) {
+	fromVaultwarden, _ := cmd.Flags().GetBool("smtp-from-vaultwarden")
+	if !cmd.Flags().Changed("smtp-from-vaultwarden") {
+		fromVaultwarden = envBoolOrDefault("VAULTAGE_SMTP_FROM_VAULTWARDEN", fromVaultwarden)
+	}
+
+	var cfg notify.SMTPConfig
+	if fromVaultwarden {
+		var err error
+		cfg, err = notify.VaultwardenSMTP(dataDir)
+		if err != nil {
+			return notify.SMTPConfig{}, err
+		}
+	}
+
+	for _, s := range []struct {
+		flag, env string
+		value     *string
+	}{
+		{"smtp-host", "VAULTAGE_SMTP_HOST", &cfg.Host},
+		{"smtp-security", "VAULTAGE_SMTP_SECURITY", &cfg.Security},
+		{"smtp-username", "VAULTAGE_SMTP_USERNAME", &cfg.Username},
+		{"smtp-password", "VAULTAGE_SMTP_PASSWORD", &cfg.Password},
+		{"smtp-from", "VAULTAGE_SMTP_FROM", &cfg.From},
+	} {
+		// Flags that are not set on the command line may hold a value
+		// from the config file
+		if v, _ := cmd.Flags().GetString(s.flag); v != "" {
+			*s.value = v
+		}
+		if !cmd.Flags().Changed(s.flag) {
+			*s.value = envStringOrDefault(s.env, *s.value)
+		}
+	}
+
+	if port, _ := cmd.Flags().GetInt("smtp-port"); port != 0 {
+		cfg.Port = port
+	}
+	if !cmd.Flags().Changed("smtp-port") {
+		cfg.Port = int(envInt64OrDefault("VAULTAGE_SMTP_PORT", int64(cfg.Port)))
+	}
+
+	return cfg, nil
+}
+
+// Sends the start ping, logging failures.
+func pingStart(ctx context.Context, ping *notify.Ping) {
+	if ping == nil {
+		return
+	}
+	if err := ping.Start(ctx); err != nil {
+		slog.ErrorContext(ctx, "start ping failed", logging.Err(err))
+	}
+}
+
+// Reads and parses a notification template, returning nil for an empty path.
+func loadTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return nil, nil
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return notify.ParseTemplate(filepath.Base(path), string(text))
+}
+
+// Parses "Name: value" lines into a header.
+func parseHeaders(lines []string) (http.Header, error) {
+	header := http.Header{}
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf(`invalid header %q: expected "Name: value"`, line)
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// Splits s into lines, dropping empty ones.
+func nonEmptyLines(s string) []string {
+	var lines []string
+	for line := range strings.Lines(s) {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Sends keepalive pings from watcher status updates. The watcher loop
+// publishes its status at least every watcher.HeartbeatInterval, so the
+// pings stop when the loop is stuck, not only when the process dies.
+type keepalive struct {
+	ctx      context.Context
+	ping     *notify.Ping
+	interval time.Duration
+	last     time.Time
+	sending  atomic.Bool
+}
+
+func (k *keepalive) update(s watcher.Status) {
+	// A success ping while a backup runs would end the run the monitor
+	// is timing since the start ping
+	if s.Running || time.Since(k.last) < k.interval {
+		return
+	}
+	if !k.sending.CompareAndSwap(false, true) {
+		return
+	}
+	k.last = time.Now()
+
+	go func() {
+		defer k.sending.Store(false)
+		if err := k.ping.Keepalive(k.ctx, s.LastError); err != nil {
+			slog.ErrorContext(k.ctx, "keepalive ping failed", logging.Err(err))
+		}
+	}()
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mijolabs/vaultage/backup"
+)
+
+// Time limit for --age-passphrase-command, which may wait for a keyring
+// or a PIN entry dialog.
+const passphraseCommandTimeout = 2 * time.Minute
+
+// Runs --age-passphrase-command, if set, and stores its output as the
+// passphrase of cfg.
+func resolvePassphraseCommand(ctx context.Context, cmd *cobra.Command, cfg *backup.Config) error {
+	command, _ := cmd.Flags().GetString("age-passphrase-command")
+	if !cmd.Flags().Changed("age-passphrase-command") {
+		command = envStringOrDefault("VAULTAGE_AGE_PASSPHRASE_COMMAND", command)
+	}
+	if command == "" || cfg.WithoutEncryption {
+		return nil
+	}
+	if cfg.AgePassphrase != "" {
+		return fmt.Errorf("--age-passphrase and --age-passphrase-command are mutually exclusive")
+	}
+
+	passphrase, err := runPassphraseCommand(ctx, command)
+	if err != nil {
+		return fmt.Errorf("running --age-passphrase-command: %w", err)
+	}
+	cfg.AgePassphrase = passphrase
+	return nil
+}
+
+// Runs a shell command and returns the first line of its output. The
+// command shares vaultage's stdin and stderr, so helpers such as pass can
+// ask for a PIN.
+func runPassphraseCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, passphraseCommandTimeout)
+	defer cancel()
+
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		c = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	c.Stdin = os.Stdin
+	c.Stderr = os.Stderr
+	stdout := &bytes.Buffer{}
+	c.Stdout = stdout
+	c.WaitDelay = 5 * time.Second
+
+	if err := c.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("timed out after %s", passphraseCommandTimeout)
+		}
+		return "", err
+	}
+
+	passphrase, err := readPassphraseLine(stdout)
+	if err != nil {
+		return "", fmt.Errorf("reading output: %w", err)
+	}
+	return passphrase, nil
+}
+
+// Reads a passphrase from the first line of r, for --passphrase-stdin and
+// --age-passphrase-command. Later lines are ignored, as pass and similar
+// tools print metadata after the secret.
+func readPassphraseLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("passphrase is empty")
+	}
+	return line, nil
+}
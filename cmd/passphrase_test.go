@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mijolabs/vaultage/backup"
+)
+
+func TestReadPassphraseLine(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"secret", "secret"},
+		{"secret\n", "secret"},
+		{"secret\r\n", "secret"},
+		// pass prints metadata after the first line
+		{"secret\nlogin: admin\nurl: vault.example.com\n", "secret"},
+		{"  spaced secret  \n", "  spaced secret  "},
+	}
+	for _, tt := range tests {
+		got, err := readPassphraseLine(strings.NewReader(tt.input))
+		if err != nil || got != tt.want {
+			t.Errorf("readPassphraseLine(%q) = %q, %v, want %q", tt.input, got, err, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "\n", "\r\n", "\nsecret\n"} {
+		if _, err := readPassphraseLine(strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), "passphrase is empty") {
+			t.Errorf("readPassphraseLine(%q): expected empty passphrase error, got %v", input, err)
+		}
+	}
+}
+
+func TestRunPassphraseCommand(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("requires /bin/sh")
+	}
+	ctx := context.Background()
+
+	got, err := runPassphraseCommand(ctx, `printf 'secret\nlogin: admin\n'`)
+	if err != nil || got != "secret" {
+		t.Fatalf("runPassphraseCommand = %q, %v, want %q", got, err, "secret")
+	}
+
+	if _, err := runPassphraseCommand(ctx, "exit 3"); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("expected exit status error, got %v", err)
+	}
+	if _, err := runPassphraseCommand(ctx, "true"); err == nil || !strings.Contains(err.Error(), "passphrase is empty") {
+		t.Fatalf("expected empty passphrase error, got %v", err)
+	}
+}
+
+func TestResolvePassphraseCommand(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("requires /bin/sh")
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		cfg  backup.Config
+		want string
+		err  string
+	}{
+		{name: "unset", cfg: backup.Config{AgePassphrase: "flag"}, want: "flag"},
+		{name: "flag", args: []string{"--age-passphrase-command", "echo from-flag"}, want: "from-flag"},
+		{name: "env", env: "echo from-env", want: "from-env"},
+		{name: "flag over env", args: []string{"--age-passphrase-command", "echo from-flag"}, env: "echo from-env", want: "from-flag"},
+		{
+			// Nothing to encrypt, so the command is not run
+			name: "without encryption",
+			args: []string{"--age-passphrase-command", "exit 1"},
+			cfg:  backup.Config{WithoutEncryption: true},
+		},
+		{
+			name: "with passphrase",
+			args: []string{"--age-passphrase-command", "echo from-flag"},
+			cfg:  backup.Config{AgePassphrase: "flag"},
+			err:  "--age-passphrase and --age-passphrase-command are mutually exclusive",
+		},
+		{
+			name: "failing command",
+			args: []string{"--age-passphrase-command", "exit 1"},
+			err:  "running --age-passphrase-command: exit status 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VAULTAGE_AGE_PASSPHRASE_COMMAND", tt.env)
+			cmd := &cobra.Command{}
+			addBackupFlags(cmd)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatalf("parsing flags: %v", err)
+			}
+
+			cfg := tt.cfg
+			err := resolvePassphraseCommand(ctx, cmd, &cfg)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolvePassphraseCommand: %v", err)
+			}
+			if cfg.AgePassphrase != tt.want {
+				t.Fatalf("passphrase = %q, want %q", cfg.AgePassphrase, tt.want)
+			}
+		})
+	}
+}
@@ -3,10 +3,13 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
+
+	"github.com/mijolabs/vaultage/logging"
 )
 
 func banner() string {
@@ -26,7 +29,23 @@ func RootCmd(ctx context.Context) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "vaultage",
 		Short: "Vaultwarden backups with Age encryption",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyConfigFile(cmd); err != nil {
+				return err
+			}
+			if err := setupLogging(cmd); err != nil {
+				return err
+			}
+			if err := loadSecretFiles(); err != nil {
+				return err
+			}
+			return checkEnvVars(cmd.Flags())
+		},
 	}
+	cmd.PersistentFlags().String("config", "", "path to a YAML config file, whose settings apply unless set by flags or env vars (env: VAULTAGE_CONFIG)")
+	cmd.PersistentFlags().StringArray("instance", nil, "only run for this instance of the config file, repeatable (env: VAULTAGE_INSTANCE, space-separated)")
+	cmd.PersistentFlags().String("log-format", logging.FormatText, "log format: text or json (env: VAULTAGE_LOG_FORMAT)")
+	cmd.PersistentFlags().String("log-level", "info", "minimum log level: debug, info, warn or error (env: VAULTAGE_LOG_LEVEL)")
 
 	originalHelp := cmd.HelpFunc()
 	cmd.SetHelpFunc(func(c *cobra.Command, args []string) {
@@ -40,6 +59,31 @@ func RootCmd(ctx context.Context) *cobra.Command {
 
 	cmd.AddCommand(Backup(ctx))
 	cmd.AddCommand(Watch(ctx))
+	cmd.AddCommand(Healthcheck(ctx))
+	cmd.AddCommand(History(ctx))
+	cmd.AddCommand(Config(ctx))
+	cmd.AddCommand(Doctor(ctx))
 
 	return cmd
 }
+
+// Installs the default slog logger, which the standard log package
+// writes through as well.
+func setupLogging(cmd *cobra.Command) error {
+	format, _ := cmd.Flags().GetString("log-format")
+	if !cmd.Flags().Changed("log-format") {
+		format = envStringOrDefault("VAULTAGE_LOG_FORMAT", format)
+	}
+
+	level, _ := cmd.Flags().GetString("log-level")
+	if !cmd.Flags().Changed("log-level") {
+		level = envStringOrDefault("VAULTAGE_LOG_LEVEL", level)
+	}
+
+	logger, err := logging.New(os.Stderr, format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
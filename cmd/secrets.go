@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Env vars holding secrets. Each can also be read from the file named by
+// the same variable with a _FILE suffix, such as a Docker secret, or from
+// the systemd credential of the same name.
+var secretEnvVars = []string{
+	"VAULTAGE_AGE_PASSPHRASE",
+	"VAULTAGE_DESTINATION",
+	"VAULTAGE_S3_ACCESS_KEY_ID",
+	"VAULTAGE_S3_SECRET_ACCESS_KEY",
+	"VAULTAGE_S3_SESSION_TOKEN",
+	"VAULTAGE_SFTP_KEY_PASSPHRASE",
+	"VAULTAGE_WEBDAV_PASSWORD",
+	"VAULTAGE_WEBDAV_TOKEN",
+	"VAULTAGE_NOTIFY",
+	"VAULTAGE_NOTIFY_WEBHOOK",
+	"VAULTAGE_NOTIFY_WEBHOOK_SECRET",
+	"VAULTAGE_NOTIFY_WEBHOOK_HEADERS",
+	"VAULTAGE_SMTP_USERNAME",
+	"VAULTAGE_SMTP_PASSWORD",
+	"VAULTAGE_PING_URL",
+	// Vaultwarden's own, for --smtp-from-vaultwarden
+	"SMTP_PASSWORD",
+}
+
+// Returns the names of the env vars that hold secrets or point to them,
+// which exec destinations do not pass on to their commands.
+func secretEnvNames() []string {
+	names := []string{"CREDENTIALS_DIRECTORY"}
+	for _, name := range secretEnvVars {
+		names = append(names, name, name+"_FILE")
+	}
+	return names
+}
+
+// Sets the secret env vars that are given as files. The value is read from
+// the file named by NAME_FILE, or else from $CREDENTIALS_DIRECTORY/NAME,
+// with trailing newlines removed. A variable set directly wins over a
+// credential, but must not be combined with NAME_FILE.
+func loadSecretFiles() error {
+	credentialsDir := os.Getenv("CREDENTIALS_DIRECTORY")
+
+	for _, name := range secretEnvVars {
+		path := os.Getenv(name + "_FILE")
+		if path != "" && os.Getenv(name) != "" {
+			return fmt.Errorf("%s and %s_FILE are mutually exclusive", name, name)
+		}
+		if path == "" && credentialsDir != "" && os.Getenv(name) == "" {
+			candidate := filepath.Join(credentialsDir, name)
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+			}
+		}
+		if path == "" {
+			continue
+		}
+
+		value, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		os.Setenv(name, value)
+	}
+	return nil
+}
+
+// Reads a secret from a file, warning when others may read it.
+func readSecretFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+	if looseSecretPermissions(info) {
+		slog.Warn("secret file is accessible by other users, restrict it with chmod 600 or 400",
+			"path", path, "mode", info.Mode().Perm().String())
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrPermission) {
+		return "", fmt.Errorf("%w, check the file owner and mode", err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package cmd
+
+import "io/fs"
+
+// Reports whether users other than the owner and group may access a secret file.
+// Permission bits do not reflect access on non-unix platforms, so no file is reported.
+func looseSecretPermissions(info fs.FileInfo) bool {
+	return false
+}
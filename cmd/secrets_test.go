@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mijolabs/vaultage/destination"
+)
+
+// Clears the secret env vars, their _FILE variants and the systemd
+// credentials directory for the test, restoring them afterwards.
+func clearSecretEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	for _, name := range secretEnvVars {
+		t.Setenv(name, "")
+		t.Setenv(name+"_FILE", "")
+	}
+}
+
+// Writes a secret file and returns its path.
+func writeSecret(t *testing.T, dir, name, value string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(value), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	credentials := t.TempDir()
+	writeSecret(t, credentials, "VAULTAGE_SMTP_PASSWORD", "from-credential\n")
+	writeSecret(t, credentials, "VAULTAGE_WEBDAV_TOKEN", "ignored")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]string
+		err  string
+	}{
+		{
+			name: "file",
+			env:  map[string]string{"VAULTAGE_AGE_PASSPHRASE_FILE": writeSecret(t, dir, "passphrase", "correct horse\n")},
+			want: map[string]string{"VAULTAGE_AGE_PASSPHRASE": "correct horse"},
+		},
+		{
+			// Only trailing newlines are removed
+			name: "trailing newlines",
+			env:  map[string]string{"VAULTAGE_S3_SECRET_ACCESS_KEY_FILE": writeSecret(t, dir, "s3", "  secret \r\n\r\n")},
+			want: map[string]string{"VAULTAGE_S3_SECRET_ACCESS_KEY": "  secret "},
+		},
+		{
+			name: "credentials directory",
+			env:  map[string]string{"CREDENTIALS_DIRECTORY": credentials, "VAULTAGE_WEBDAV_TOKEN": "direct"},
+			want: map[string]string{"VAULTAGE_SMTP_PASSWORD": "from-credential", "VAULTAGE_WEBDAV_TOKEN": "direct"},
+		},
+		{
+			name: "file over credential",
+			env: map[string]string{
+				"CREDENTIALS_DIRECTORY":       credentials,
+				"VAULTAGE_SMTP_PASSWORD_FILE": writeSecret(t, dir, "smtp", "from-file"),
+			},
+			want: map[string]string{"VAULTAGE_SMTP_PASSWORD": "from-file"},
+		},
+		{
+			name: "variable and file",
+			env: map[string]string{
+				"VAULTAGE_AGE_PASSPHRASE":      "direct",
+				"VAULTAGE_AGE_PASSPHRASE_FILE": writeSecret(t, dir, "both", "from-file"),
+			},
+			err: "VAULTAGE_AGE_PASSPHRASE and VAULTAGE_AGE_PASSPHRASE_FILE are mutually exclusive",
+		},
+		{
+			name: "missing file",
+			env:  map[string]string{"VAULTAGE_PING_URL_FILE": filepath.Join(dir, "missing")},
+			err:  "reading VAULTAGE_PING_URL",
+		},
+		{
+			name: "directory",
+			env:  map[string]string{"VAULTAGE_PING_URL_FILE": dir},
+			err:  "is a directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearSecretEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			err := loadSecretFiles()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSecretFiles: %v", err)
+			}
+			for k, want := range tt.want {
+				if got := os.Getenv(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecretsHiddenFromExec(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("requires /bin/sh")
+	}
+	clearSecretEnv(t)
+
+	dir := t.TempDir()
+	credentials := t.TempDir()
+	writeSecret(t, credentials, "VAULTAGE_WEBDAV_PASSWORD", "webdav-secret")
+	t.Setenv("CREDENTIALS_DIRECTORY", credentials)
+	t.Setenv("SMTP_PASSWORD_FILE", writeSecret(t, dir, "smtp", "smtp-secret\n"))
+	if err := loadSecretFiles(); err != nil {
+		t.Fatalf("loadSecretFiles: %v", err)
+	}
+
+	// The command dumps its environment next to the archive
+	script := writeSecret(t, dir, "upload.sh", "#!/bin/sh\ncat > /dev/null\nenv > \"$1/env\"\n")
+	if err := os.Chmod(script, 0700); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	dest, err := destination.Open("exec://"+script+"?arg="+out, resolveDestinationOptions())
+	if err != nil {
+		t.Fatalf("opening destination: %v", err)
+	}
+	if err := dest.Put(context.Background(), "vaultage-1.tar.age", strings.NewReader("archive")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	env, err := os.ReadFile(filepath.Join(out, "env"))
+	if err != nil {
+		t.Fatalf("reading environment: %v", err)
+	}
+	for _, leak := range []string{"smtp-secret", "webdav-secret", "SMTP_PASSWORD", "CREDENTIALS_DIRECTORY"} {
+		if strings.Contains(string(env), leak) {
+			t.Errorf("command environment contains %s:\n%s", leak, env)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package cmd
+
+import "io/fs"
+
+// Reports whether users other than the owner and group may access a secret file.
+func looseSecretPermissions(info fs.FileInfo) bool {
+	return info.Mode().Perm()&0o007 != 0
+}
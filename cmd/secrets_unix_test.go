@@ -0,0 +1,44 @@
+//go:build unix
+
+package cmd
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadSecretFile_LoosePermissions(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		mode os.FileMode
+		warn bool
+	}{
+		{0600, false},
+		{0440, false},
+		{0644, true},
+		{0606, true},
+	} {
+		logs.Reset()
+		path := writeSecret(t, dir, "secret", "value\n")
+		if err := os.Chmod(path, tt.mode); err != nil {
+			t.Fatal(err)
+		}
+
+		value, err := readSecretFile(path)
+		if err != nil || value != "value" {
+			t.Fatalf("readSecretFile = %q, %v", value, err)
+		}
+		if warned := strings.Contains(logs.String(), "secret file is accessible by other users"); warned != tt.warn {
+			t.Errorf("mode %v: warned = %v, want %v, logs:\n%s", tt.mode, warned, tt.warn, logs.String())
+		}
+		os.Remove(path)
+	}
+}
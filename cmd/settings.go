@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Matches the env var a flag falls back to in its usage, such as
+// "(env: VAULTAGE_DEBOUNCE)".
+var flagEnvPattern = regexp.MustCompile(`\(env: ([A-Z0-9_]+)`)
+
+// Env vars read without a flag, such as destination credentials.
+var extraEnvVars = []string{
+	"VAULTAGE_S3_ACCESS_KEY_ID",
+	"VAULTAGE_S3_SECRET_ACCESS_KEY",
+	"VAULTAGE_S3_SESSION_TOKEN",
+	"VAULTAGE_SFTP_KEY_FILE",
+	"VAULTAGE_SFTP_KEY_PASSPHRASE",
+	"VAULTAGE_WEBDAV_PASSWORD",
+	"VAULTAGE_WEBDAV_TOKEN",
+}
+
+// Returns the env var the flag falls back to, or "" if it has none.
+func flagEnvVar(f *pflag.Flag) string {
+	if m := flagEnvPattern.FindStringSubmatch(f.Usage); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Checks that the env vars of the flags in fs hold valid values for the
+// flag types, so that typos are not silently replaced by defaults.
+func checkEnvVars(fs *pflag.FlagSet) error {
+	var errs []error
+	fs.VisitAll(func(f *pflag.Flag) {
+		name := flagEnvVar(f)
+		val := os.Getenv(name)
+		if name == "" || val == "" {
+			return
+		}
+		if err := checkEnvValue(f.Value.Type(), val); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", val, name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Checks that val parses as a value of the pflag type typ.
+func checkEnvValue(typ, val string) error {
+	switch typ {
+	case "bool":
+		if _, ok := boolMap[strings.ToLower(val)]; !ok {
+			return errors.New("expected true, false, yes, no, 1 or 0")
+		}
+	case "duration":
+		if _, err := time.ParseDuration(val); err != nil {
+			return errors.New("expected a duration such as 90s, 10m or 1h30m")
+		}
+	case "int", "int64":
+		if _, err := strconv.ParseInt(val, 10, 64); err != nil {
+			return errors.New("expected a whole number")
+		}
+	}
+	return nil
+}
+
+// Where the value of a setting comes from, from highest to lowest
+// precedence.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// Returns the effective value of a flag and where it comes from. fromFile
+// holds the settings the config file set for the flag's command.
+func settingValue(f *pflag.Flag, fromFile settings) (string, string) {
+	if f.Changed {
+		return flagValueString(f), sourceFlag
+	}
+	if name := flagEnvVar(f); name != "" {
+		if val := os.Getenv(name); val != "" {
+			return val, sourceEnv
+		}
+	}
+	if _, ok := fromFile[f.Name]; ok {
+		return flagValueString(f), sourceFile
+	}
+	return flagValueString(f), sourceDefault
+}
+
+// Returns the value of a flag as it would be written in an env var.
+func flagValueString(f *pflag.Flag) string {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		return strings.Join(sv.GetSlice(), " ")
+	}
+	return f.Value.String()
+}
+
+// Returns val with secrets replaced, for the setting read from the env var
+// name. Only passwords are removed from destination URLs, as they tell
+// where the backups go.
+func redactSetting(name, val string) string {
+	if val == "" || !slices.Contains(secretEnvVars, name) {
+		return val
+	}
+	if name != "VAULTAGE_DESTINATION" {
+		return "[REDACTED]"
+	}
+
+	fields := strings.Fields(val)
+	for i, field := range fields {
+		u, err := url.Parse(field)
+		if err != nil {
+			fields[i] = "[REDACTED]"
+			continue
+		}
+		fields[i] = u.Redacted()
+	}
+	return strings.Join(fields, " ")
+}
@@ -2,11 +2,19 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/logging"
+	"github.com/mijolabs/vaultage/metrics"
+	"github.com/mijolabs/vaultage/notify"
 	"github.com/mijolabs/vaultage/watcher"
 )
 
@@ -17,46 +25,249 @@ func Watch(ctx context.Context) *cobra.Command {
 		Short: "Watch for changes and perform backups",
 		Use:   "watch [data dir]",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Validate data directory
-			dataDir, err := validateDataDirFromArgs(args)
+			instances, err := resolveInstances(cmd, addWatchFlags)
 			if err != nil {
-				return fmt.Errorf("validating data directory: %w", err)
+				return err
+			}
+			if len(instances) > 1 && len(args) > 0 {
+				return fmt.Errorf("a data directory argument cannot be used with several instances, set data-dir in the config file")
 			}
 
-			cfg := resolveBackupFlags(cmd)
-			cfg.DataDir = dataDir
-
-			// Resolve watch-specific debounce flag
-			debounce, _ := cmd.Flags().GetDuration("debounce")
-			if !cmd.Flags().Changed("debounce") {
-				debounce = envDurationOrDefault("VAULTAGE_DEBOUNCE", debounce)
+			listenAddr, _ := cmd.Flags().GetString("listen")
+			if !cmd.Flags().Changed("listen") {
+				listenAddr = envStringOrDefault("VAULTAGE_LISTEN_ADDR", listenAddr)
 			}
 
-			// Validate age options
-			if !cfg.WithoutEncryption {
-				if (cfg.AgePassphrase == "") == (cfg.AgeKeyFile == "") {
-					return fmt.Errorf(
-						"watch mode requires exactly one of --age-passphrase or --age-key-file " +
-							"to be set via cli flags or env vars",
-					)
+			watches := make([]*watchInstance, len(instances))
+			cfgs := make([]backup.Config, len(instances))
+			for i, inst := range instances {
+				watches[i], err = resolveWatchInstance(ctx, inst, args)
+				if err != nil {
+					return inst.wrap(err)
 				}
+				cfgs[i] = watches[i].cfg.Config
 			}
+			if err := checkInstanceConflicts(cfgs); err != nil {
+				return err
+			}
+
+			if listenAddr != "" {
+				registry := metrics.NewRegistry()
+				names := make([]string, len(watches))
+				for i, w := range watches {
+					names[i] = w.name
+				}
+				health := newHealthHandler(names)
+				for i, w := range watches {
+					m := registry.Instance(w.name)
+					w.onBackup = append(w.onBackup, m.ObserveBackup)
+					w.onStatus = append(w.onStatus, m.ObserveStatus, health.updater(i))
+				}
 
-			watchCfg := watcher.Config{
-				Config:   cfg,
-				Debounce: debounce,
+				mux := http.NewServeMux()
+				mux.Handle("GET /metrics", registry.Handler())
+				mux.Handle("GET /healthz", health)
+				if err := serveHTTP(ctx, listenAddr, mux); err != nil {
+					return err
+				}
 			}
 
-			return watcher.Watch(ctx, watchCfg)
+			return runWatches(ctx, watches)
 		},
 	}
 
+	addWatchFlags(cmd)
+
+	return cmd
+}
+
+// Registers the flags of the watch command.
+func addWatchFlags(cmd *cobra.Command) {
 	addBackupFlags(cmd)
+	addNotifyFlags(cmd)
 	cmd.Flags().Duration(
 		"debounce",
 		10*time.Minute,
 		"trailing quiet period before backup is performed (env: VAULTAGE_DEBOUNCE)",
 	)
+	cmd.Flags().Duration(
+		"retry-backoff",
+		30*time.Second,
+		"delay before retrying a failed backup, doubled on every failure, 0 disables retries (env: VAULTAGE_RETRY_BACKOFF)",
+	)
+	cmd.Flags().Duration(
+		"retry-max-backoff",
+		30*time.Minute,
+		"maximum delay between backup retries, 0 means a day (env: VAULTAGE_RETRY_MAX_BACKOFF)",
+	)
+	cmd.Flags().Bool(
+		"backup-on-start",
+		false,
+		"perform a backup when the watcher starts (env: VAULTAGE_BACKUP_ON_START)",
+	)
+	cmd.Flags().Duration(
+		"backup-on-start-max-age",
+		0,
+		"only back up on start if the newest backup is older than this (env: VAULTAGE_BACKUP_ON_START_MAX_AGE)",
+	)
+	cmd.Flags().Bool(
+		"backup-on-start-if-changed",
+		false,
+		"only back up on start if the database content changed since the last successful backup (env: VAULTAGE_BACKUP_ON_START_IF_CHANGED)",
+	)
+	cmd.Flags().Duration(
+		"ping-keepalive",
+		5*time.Minute,
+		"interval of keepalive pings to --ping-url, repeating the last result, 0 disables them (env: VAULTAGE_PING_KEEPALIVE)",
+	)
+	cmd.Flags().String(
+		"listen",
+		"",
+		"address to serve Prometheus metrics and the health endpoint on, e.g. :9090, disabled when empty (env: VAULTAGE_LISTEN_ADDR)",
+	)
+}
 
-	return cmd
+// watchInstance is the watcher of one instance, with the observers of its
+// backups and status.
+type watchInstance struct {
+	instance
+	cfg      watcher.Config
+	notifier *notify.Dispatcher
+	onBackup []func(backup.Result, error)
+	onStatus []func(watcher.Status)
+}
+
+// Reads the watch settings of one instance and sets up its notifications.
+func resolveWatchInstance(ctx context.Context, inst instance, args []string) (*watchInstance, error) {
+	cmd := inst.cmd
+	cfg, err := resolveInstanceConfig(ctx, inst, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve watch-specific debounce flag
+	debounce, _ := cmd.Flags().GetDuration("debounce")
+	if !cmd.Flags().Changed("debounce") {
+		debounce = envDurationOrDefault("VAULTAGE_DEBOUNCE", debounce)
+	}
+
+	// Resolve startup backup flags
+	backupOnStart, _ := cmd.Flags().GetBool("backup-on-start")
+	if !cmd.Flags().Changed("backup-on-start") {
+		backupOnStart = envBoolOrDefault("VAULTAGE_BACKUP_ON_START", backupOnStart)
+	}
+
+	backupOnStartMaxAge, _ := cmd.Flags().GetDuration("backup-on-start-max-age")
+	if !cmd.Flags().Changed("backup-on-start-max-age") {
+		backupOnStartMaxAge = envDurationOrDefault("VAULTAGE_BACKUP_ON_START_MAX_AGE", backupOnStartMaxAge)
+	}
+
+	backupOnStartIfChanged, _ := cmd.Flags().GetBool("backup-on-start-if-changed")
+	if !cmd.Flags().Changed("backup-on-start-if-changed") {
+		backupOnStartIfChanged = envBoolOrDefault("VAULTAGE_BACKUP_ON_START_IF_CHANGED", backupOnStartIfChanged)
+	}
+
+	// Resolve retry flags
+	retryBackoff, _ := cmd.Flags().GetDuration("retry-backoff")
+	if !cmd.Flags().Changed("retry-backoff") {
+		retryBackoff = envDurationOrDefault("VAULTAGE_RETRY_BACKOFF", retryBackoff)
+	}
+
+	retryMaxBackoff, _ := cmd.Flags().GetDuration("retry-max-backoff")
+	if !cmd.Flags().Changed("retry-max-backoff") {
+		retryMaxBackoff = envDurationOrDefault("VAULTAGE_RETRY_MAX_BACKOFF", retryMaxBackoff)
+	}
+
+	// Validate age options
+	if !cfg.WithoutEncryption {
+		if (cfg.AgePassphrase == "") == (cfg.AgeKeyFile == "") {
+			return nil, fmt.Errorf(
+				"watch mode requires exactly one of --age-passphrase, --age-passphrase-command or --age-key-file " +
+					"to be set via cli flags or env vars",
+			)
+		}
+	}
+
+	w := &watchInstance{
+		instance: inst,
+		cfg: watcher.Config{
+			Config:                 cfg,
+			Debounce:               debounce,
+			BackupOnStart:          backupOnStart,
+			BackupOnStartMaxAge:    backupOnStartMaxAge,
+			BackupOnStartIfChanged: backupOnStartIfChanged,
+			Retry: watcher.RetryConfig{
+				Backoff:    retryBackoff,
+				MaxBackoff: retryMaxBackoff,
+			},
+			StatusFile: filepath.Join(cfg.StateDir, statusFileName),
+		},
+	}
+
+	notifier, ping, err := resolveNotifyFlags(cmd, cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	w.notifier = notifier
+
+	pingKeepalive, _ := cmd.Flags().GetDuration("ping-keepalive")
+	if !cmd.Flags().Changed("ping-keepalive") {
+		pingKeepalive = envDurationOrDefault("VAULTAGE_PING_KEEPALIVE", pingKeepalive)
+	}
+
+	if notifier.Len() > 0 {
+		w.onBackup = append(w.onBackup, func(result backup.Result, err error) {
+			notifier.Dispatch(ctx, notify.Events(result, err)...)
+		})
+	}
+	if ping != nil {
+		w.cfg.OnBackupStart = func() { pingStart(ctx, ping) }
+		if pingKeepalive > 0 {
+			k := &keepalive{ctx: ctx, ping: ping, interval: pingKeepalive}
+			w.onStatus = append(w.onStatus, k.update)
+		}
+	}
+
+	return w, nil
+}
+
+// Runs the watchers until the context is cancelled or one of them fails,
+// which stops the others.
+func runWatches(ctx context.Context, watches []*watchInstance) error {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, len(watches))
+	var wg sync.WaitGroup
+	for i, w := range watches {
+		w.cfg.OnBackup = func(result backup.Result, err error) {
+			for _, fn := range w.onBackup {
+				fn(result, err)
+			}
+		}
+		w.cfg.OnStatus = func(s watcher.Status) {
+			for _, fn := range w.onStatus {
+				fn(s)
+			}
+		}
+
+		wg.Go(func() {
+			defer w.notifier.Wait()
+
+			ctx := watchCtx
+			if w.name != "" {
+				ctx = logging.With(ctx, "instance", w.name)
+			}
+			if err := watcher.Watch(ctx, w.cfg); err != nil && watchCtx.Err() == nil {
+				errs[i] = w.wrap(err)
+			}
+			cancel()
+		})
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return ctx.Err()
 }
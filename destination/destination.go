@@ -0,0 +1,150 @@
+// Package destination provides the storage backends backup archives are written to.
+package destination
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object describes an archive stored in a destination.
+type Object struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Destination is a place backup archives are stored.
+//
+// Names are slash-separated paths relative to the destination, such as
+// 2026/10/vaultage-20261018T020000Z.tar.age. Put creates the directories a
+// name needs, and List includes objects in subdirectories. Stat returns an
+// error wrapping fs.ErrNotExist when the object does not exist.
+type Destination interface {
+	// Put streams r into the object called name. The object must only
+	// become visible under name once it has been written completely, and
+	// an existing object is never replaced: Put returns an error wrapping
+	// fs.ErrExist instead.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// List returns all objects in the destination.
+	List(ctx context.Context) ([]Object, error)
+	// Delete removes the object called name.
+	Delete(ctx context.Context, name string) error
+	// Stat returns information about the object called name.
+	Stat(ctx context.Context, name string) (Object, error)
+	// String describes the destination for logs. It must not contain secrets.
+	String() string
+}
+
+// Options carries settings for destinations that are not part of the URL,
+// such as credentials.
+type Options struct {
+	S3     S3Credentials
+	SFTP   SFTPCredentials
+	WebDAV WebDAVCredentials
+	Exec   ExecOptions
+}
+
+// Open returns the destination selected by rawURL.
+//
+// Supported forms:
+//   - a plain path or file:///path for a local directory
+//   - s3://bucket/prefix for S3-compatible object storage, see NewS3
+//   - sftp://user@host:port/path for a directory on an SSH server, see NewSFTP
+//   - webdav://host/path or webdavs://host/path for a WebDAV collection, see NewWebDAV
+//   - exec:///path/to/command to pipe archives into a command, see NewExec
+func Open(rawURL string, opts Options) (Destination, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("empty destination")
+	}
+
+	// Plain paths, including Windows drive letters, are local directories
+	if !strings.Contains(rawURL, "://") {
+		return NewLocal(rawURL), nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing destination %q: %w", Redact(rawURL), err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file destination must not have a host: %s", Redact(rawURL))
+		}
+		return NewLocal(u.Path), nil
+	case "s3":
+		return NewS3(u, opts.S3)
+	case "sftp":
+		return NewSFTP(u, opts.SFTP)
+	case "webdav", "webdavs":
+		return NewWebDAV(u, opts.WebDAV)
+	case "exec":
+		return NewExec(u, opts.Exec)
+	default:
+		return nil, fmt.Errorf("unsupported destination scheme %q", u.Scheme)
+	}
+}
+
+// LocalPath returns the directory of a local destination, given as a
+// plain path or a file:// URL, without opening it. The bool result is
+// false for other destinations.
+func LocalPath(rawURL string) (string, bool) {
+	if rawURL != "" && !strings.Contains(rawURL, "://") {
+		return rawURL, true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// Returns a random suffix for temporary names, so concurrent uploads of
+// the same name do not write to the same temporary object.
+func tempSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Redact returns rawURL with any password in the user info replaced,
+// for use in logs and error messages.
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// Returns an error naming the first query parameter not in allowed.
+func checkParams(q url.Values, allowed ...string) error {
+	for key := range q {
+		if !slices.Contains(allowed, key) {
+			return fmt.Errorf("unknown destination parameter %q", key)
+		}
+	}
+	return nil
+}
+
+// Parses an optional boolean query parameter.
+func boolParam(q url.Values, key string) (bool, error) {
+	v := q.Get(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: expected true or false", key, v)
+	}
+	return b, nil
+}
@@ -0,0 +1,164 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exec pipes archives into a user-configured command, for storage vaultage
+// does not support natively such as rclone remotes or tape. The command
+// only ever sees the archive as written by backup.Perform, so unless
+// encryption is disabled it receives age-encrypted data.
+//
+// Listing and deleting is not possible, so retention cannot be applied.
+type Exec struct {
+	path      string
+	args      []string
+	timeout   time.Duration
+	hiddenEnv []string
+}
+
+// ExecOptions configures exec destinations.
+type ExecOptions struct {
+	// HiddenEnv names env vars that are not passed on to the command,
+	// such as secrets. VAULTAGE_* variables are never passed on.
+	HiddenEnv []string
+}
+
+// Default time limit for a single run of the command.
+const defaultExecTimeout = 10 * time.Minute
+
+// Limit of command output kept for error messages.
+const execOutputLimit = 4 << 10
+
+// NewExec returns a destination for a URL of the form
+//
+//	exec:///usr/local/bin/upload.sh?arg=--flag&arg=value&timeout=10m
+//
+// The archive is written to the command's stdin. VAULTAGE_FILENAME holds
+// the archive name, VAULTAGE_SIZE its size when known, and
+// VAULTAGE_TIMESTAMP the time of the upload. A non-zero exit status or
+// exceeding the timeout fails the upload. The command inherits the
+// environment except for VAULTAGE_* and opts.HiddenEnv.
+func NewExec(u *url.URL, opts ExecOptions) (*Exec, error) {
+	q := u.Query()
+	if err := checkParams(q, "arg", "timeout"); err != nil {
+		return nil, err
+	}
+	if u.Host != "" {
+		return nil, fmt.Errorf("exec destination must not have a host, use exec:///path/to/command")
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("exec destination requires a command path")
+	}
+
+	timeout := defaultExecTimeout
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q: expected a positive duration", v)
+		}
+		timeout = d
+	}
+
+	return &Exec{path: u.Path, args: q["arg"], timeout: timeout, hiddenEnv: opts.HiddenEnv}, nil
+}
+
+func (e *Exec) String() string {
+	return "exec://" + e.path
+}
+
+// Put runs the command with the archive on stdin.
+func (e *Exec) Put(ctx context.Context, name string, r io.Reader) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.path, e.args...)
+	cmd.Stdin = r
+	cmd.Env = append(commandEnv(e.hiddenEnv),
+		"VAULTAGE_FILENAME="+name,
+		"VAULTAGE_TIMESTAMP="+time.Now().UTC().Format(time.RFC3339),
+	)
+	if size, ok := readerSize(r); ok {
+		cmd.Env = append(cmd.Env, "VAULTAGE_SIZE="+strconv.FormatInt(size, 10))
+	}
+
+	output := &limitedBuffer{limit: execOutputLimit}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Do not hang on children that keep the output pipes open
+	cmd.WaitDelay = 5 * time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", e.timeout)
+		}
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return fmt.Errorf("running %s: %w: %s", e.path, err, out)
+		}
+		return fmt.Errorf("running %s: %w", e.path, err)
+	}
+	return nil
+}
+
+func (e *Exec) List(ctx context.Context) ([]Object, error) {
+	return nil, fmt.Errorf("exec destination cannot list archives: %w", errors.ErrUnsupported)
+}
+
+func (e *Exec) Delete(ctx context.Context, name string) error {
+	return fmt.Errorf("exec destination cannot delete archives: %w", errors.ErrUnsupported)
+}
+
+func (e *Exec) Stat(ctx context.Context, name string) (Object, error) {
+	return Object{}, fmt.Errorf("exec destination cannot stat archives: %w", errors.ErrUnsupported)
+}
+
+// Returns the environment for the command without vaultage's own settings,
+// which may contain secrets such as the age passphrase, and without the
+// hidden variables.
+func commandEnv(hidden []string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, "VAULTAGE_") && !slices.Contains(hidden, name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// Returns the number of bytes left in r, if r can tell.
+func readerSize(r io.Reader) (int64, bool) {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len()), true
+	case *os.File:
+		if info, err := v.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size(), true
+		}
+	}
+	return 0, false
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
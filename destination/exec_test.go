@@ -0,0 +1,78 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes an executable shell script and returns its path.
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "upload.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExec_Put(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("requires /bin/sh")
+	}
+	t.Setenv("VAULTAGE_AGE_PASSPHRASE", "secret")
+	t.Setenv("SMTP_PASSWORD", "secret")
+	t.Setenv("SMTP_HOST", "mail.example.com")
+
+	out := t.TempDir()
+	script := writeScript(t, `cat > "$1/$VAULTAGE_FILENAME"
+echo "$VAULTAGE_SIZE ${VAULTAGE_AGE_PASSPHRASE:-unset} ${SMTP_PASSWORD:-unset} $SMTP_HOST" > "$1/meta"
+`)
+	dest, err := Open("exec://"+script+"?arg="+out, Options{Exec: ExecOptions{HiddenEnv: []string{"SMTP_PASSWORD"}}})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if err := dest.Put(context.Background(), "vaultage-1.tar.age", strings.NewReader("archive")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "vaultage-1.tar.age")); err != nil || string(data) != "archive" {
+		t.Fatalf("unexpected archive: %q, %v", data, err)
+	}
+	if meta, _ := os.ReadFile(filepath.Join(out, "meta")); string(meta) != "7 unset unset mail.example.com\n" {
+		t.Fatalf("unexpected environment: %q", meta)
+	}
+
+	if _, err := dest.List(context.Background()); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected errors.ErrUnsupported from List, got %v", err)
+	}
+}
+
+func TestExec_PutFailure(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("requires /bin/sh")
+	}
+
+	script := writeScript(t, "cat > /dev/null\necho 'remote not found' >&2\nexit 3\n")
+	dest, err := Open("exec://"+script, Options{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	err = dest.Put(context.Background(), "vaultage-1.tar.age", strings.NewReader("archive"))
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "remote not found") {
+		t.Fatalf("expected exit status and output in error, got %v", err)
+	}
+
+	slow := writeScript(t, "exec sleep 10\n")
+	dest, err = Open("exec://"+slow+"?timeout=100ms", Options{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	err = dest.Put(context.Background(), "vaultage-1.tar.age", strings.NewReader("archive"))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores archives in a directory on the local filesystem.
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a destination writing into dir. An empty dir is the
+// current working directory. The directory is created on the first Put.
+func NewLocal(dir string) *Local {
+	if dir == "" {
+		dir = "."
+	}
+	return &Local{dir: dir}
+}
+
+// Dir returns the directory archives are written to.
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+func (l *Local) String() string {
+	return l.dir
+}
+
+// Put writes r to a hidden temp file in the directory and hard links it to
+// name once it has been synced, so a partial archive never appears under
+// name. Put never replaces an existing file, it returns an error wrapping
+// fs.ErrExist instead.
+func (l *Local) Put(ctx context.Context, name string, r io.Reader) error {
+	finalPath := l.path(name)
+	dir := filepath.Dir(finalPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(finalPath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	// Clean up partial file on error
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(tmp, contextReader{ctx: ctx, r: r}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing backup file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing backup file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing backup file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return fmt.Errorf("setting backup file permissions: %w", err)
+	}
+
+	if err := linkNoReplace(tmpPath, finalPath); err != nil {
+		return fmt.Errorf("storing backup file: %w", err)
+	}
+
+	return nil
+}
+
+// Moves the complete temp file to finalPath without replacing an existing
+// file. A hard link fails when finalPath exists, file systems without hard
+// links fall back to checking before renaming.
+func linkNoReplace(tmpPath, finalPath string) error {
+	err := os.Link(tmpPath, finalPath)
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	if _, err := os.Lstat(finalPath); err == nil {
+		return &fs.PathError{Op: "link", Path: finalPath, Err: fs.ErrExist}
+	}
+	return os.Rename(tmpPath, finalPath)
+}
+
+// List returns the regular files in the directory and its subdirectories,
+// skipping hidden files and directories such as in-progress uploads and
+// the state directory. A missing directory is treated as empty.
+func (l *Local) List(ctx context.Context) ([]Object, error) {
+	return l.ListDepth(ctx, -1)
+}
+
+// ListDepth is like List, but only descends maxDepth levels of
+// subdirectories. A maxDepth of 0 lists the directory itself and a
+// negative maxDepth lists the whole tree.
+func (l *Local) ListDepth(ctx context.Context, maxDepth int) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(l.dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if p == l.dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed since its directory was read
+				return nil
+			}
+			return err
+		}
+		if p == l.dir {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, p)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && maxDepth >= 0 && strings.Count(rel, string(filepath.Separator)) >= maxDepth {
+			return fs.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since ReadDir
+			return nil
+		}
+		objects = append(objects, Object{
+			Name:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading output directory: %w", err)
+	}
+
+	return objects, nil
+}
+
+// Delete removes the file and the directories it leaves empty.
+func (l *Local) Delete(ctx context.Context, name string) error {
+	p := l.path(name)
+	if err := os.Remove(p); err != nil {
+		return fmt.Errorf("deleting %s: %w", name, err)
+	}
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(l.dir, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			break
+		}
+		// Fails once a directory is not empty
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (l *Local) Stat(ctx context.Context, name string) (Object, error) {
+	info, err := os.Stat(l.path(name))
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Returns the file path of the object called name.
+func (l *Local) path(name string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(name))
+}
+
+// contextReader stops reading once ctx is cancelled, so a long copy
+// into a destination can be aborted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,176 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLocal_PutListStatDelete(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "backups")
+	dest := NewLocal(dir)
+
+	if objects, err := dest.List(ctx); err != nil || len(objects) != 0 {
+		t.Fatalf("expected empty listing for missing dir, got %v, %v", objects, err)
+	}
+
+	if err := dest.Put(ctx, "vaultage-1.tar.age", strings.NewReader("archive")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// An existing archive is never replaced, and no temp file is left behind
+	if err := dest.Put(ctx, "vaultage-1.tar.age", strings.NewReader("archive v2")); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected fs.ErrExist for an existing archive, got %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("expected only the archive in %s, got %v, %v", dir, entries, err)
+	}
+
+	objects, err := dest.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Name != "vaultage-1.tar.age" || objects[0].Size != 7 {
+		t.Fatalf("unexpected listing: %+v", objects)
+	}
+
+	obj, err := dest.Stat(ctx, "vaultage-1.tar.age")
+	if err != nil || obj.Size != 7 {
+		t.Fatalf("Stat: %+v, %v", obj, err)
+	}
+
+	if err := dest.Delete(ctx, "vaultage-1.tar.age"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := dest.Stat(ctx, "vaultage-1.tar.age"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for _, rawURL := range []string{"/backups", "./backups", "file:///backups"} {
+		dest, err := Open(rawURL, Options{})
+		if err != nil {
+			t.Fatalf("Open(%q): %v", rawURL, err)
+		}
+		if _, ok := dest.(*Local); !ok {
+			t.Fatalf("Open(%q): expected *Local, got %T", rawURL, dest)
+		}
+	}
+
+	if _, err := Open("gopher://example.com/backups", Options{}); err == nil {
+		t.Fatal("expected error for unsupported scheme")
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"/backups":             "/backups",
+		"./backups":            "./backups",
+		"file:///backups":      "/backups",
+		"s3://bucket/prefix":   "",
+		"sftp://nas/~/backups": "",
+		"":                     "",
+	}
+	for rawURL, want := range tests {
+		if got, ok := LocalPath(rawURL); got != want || ok != (want != "") {
+			t.Errorf("LocalPath(%q) = %q, %t, want %q", rawURL, got, ok, want)
+		}
+	}
+}
+
+// Exercises archive names in subdirectories, which all destinations
+// that can list must support.
+func testNestedNames(t *testing.T, dest Destination) {
+	t.Helper()
+	ctx := context.Background()
+
+	names := []string{"2026/10/vaultage-1.tar.age", "2026/11/vaultage-2.tar.age", "vaultage-3.tar.age"}
+	for _, name := range names {
+		if err := dest.Put(ctx, name, strings.NewReader("archive")); err != nil {
+			t.Fatalf("Put %s: %v", name, err)
+		}
+	}
+
+	objects, err := dest.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var listed []string
+	for _, obj := range objects {
+		listed = append(listed, obj.Name)
+	}
+	slices.Sort(listed)
+	if !slices.Equal(listed, names) {
+		t.Fatalf("listed %v, want %v", listed, names)
+	}
+
+	if obj, err := dest.Stat(ctx, names[0]); err != nil || obj.Size != 7 {
+		t.Fatalf("Stat: %+v, %v", obj, err)
+	}
+	if err := dest.Delete(ctx, names[0]); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := dest.Stat(ctx, names[0]); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+	if objects, err := dest.List(ctx); err != nil || len(objects) != 2 {
+		t.Fatalf("unexpected listing after delete: %+v, %v", objects, err)
+	}
+}
+
+func TestLocal_NestedNames(t *testing.T) {
+	dir := t.TempDir()
+	// Hidden directories such as the state directory are not listed
+	if err := os.MkdirAll(filepath.Join(dir, ".vaultage"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".vaultage", "vaultage-0.tar.age"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testNestedNames(t, NewLocal(dir))
+
+	// Directories left empty are removed
+	if _, err := os.Stat(filepath.Join(dir, "2026", "10")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected empty directory to be removed, got %v", err)
+	}
+}
+
+func TestLocal_ListDepth(t *testing.T) {
+	ctx := context.Background()
+	dest := NewLocal(t.TempDir())
+	for _, name := range []string{"a.tar", "2026/b.tar", "2026/10/c.tar"} {
+		if err := dest.Put(ctx, name, strings.NewReader("archive")); err != nil {
+			t.Fatalf("Put(%q): %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{0, []string{"a.tar"}},
+		{1, []string{"2026/b.tar", "a.tar"}},
+		{-1, []string{"2026/10/c.tar", "2026/b.tar", "a.tar"}},
+	}
+	for _, tt := range tests {
+		objects, err := dest.ListDepth(ctx, tt.depth)
+		if err != nil {
+			t.Fatalf("ListDepth(%d): %v", tt.depth, err)
+		}
+		var names []string
+		for _, obj := range objects {
+			names = append(names, obj.Name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("ListDepth(%d) = %v, want %v", tt.depth, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// S3Credentials authenticate against S3-compatible object storage.
+// When AccessKeyID is empty, the standard AWS environment variables
+// and instance metadata are used instead.
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Default size of multipart upload parts. Every part is buffered in memory.
+const defaultS3PartSize = 16 << 20
+
+// S3 stores archives in an S3-compatible bucket (AWS, Backblaze B2,
+// Wasabi, MinIO, ...). Large archives are uploaded in multiple parts.
+type S3 struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	endpoint string
+	putOpts  minio.PutObjectOptions
+	// Object Lock retention applied to every uploaded archive, if set.
+	lockDays int
+}
+
+// NewS3 returns a destination for a URL of the form
+//
+//	s3://bucket/prefix?endpoint=host:port&region=...&storage-class=...
+//
+// Supported query parameters:
+//   - endpoint: S3 API host, defaults to s3.amazonaws.com
+//   - region: bucket region
+//   - insecure: use plain HTTP
+//   - path-style: address the bucket in the path instead of the host name
+//   - storage-class: storage class of uploaded objects, e.g. STANDARD_IA
+//   - sse: server-side encryption, AES256 or aws:kms
+//   - sse-kms-key-id: KMS key for sse=aws:kms
+//   - part-size: multipart part size in MiB, at least 5
+//   - object-lock-mode: GOVERNANCE or COMPLIANCE
+//   - object-lock-days: retention period for object-lock-mode
+func NewS3(u *url.URL, creds S3Credentials) (*S3, error) {
+	q := u.Query()
+	if err := checkParams(q,
+		"endpoint", "region", "insecure", "path-style", "storage-class", "sse",
+		"sse-kms-key-id", "part-size", "object-lock-mode", "object-lock-days",
+	); err != nil {
+		return nil, err
+	}
+
+	bucket := u.Host
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 destination requires a bucket: s3://bucket/prefix")
+	}
+
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	insecure, err := boolParam(q, "insecure")
+	if err != nil {
+		return nil, err
+	}
+	pathStyle, err := boolParam(q, "path-style")
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *credentials.Credentials
+	if creds.AccessKeyID != "" {
+		cred = credentials.NewStaticV4(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+	} else {
+		cred = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{},
+		})
+	}
+
+	lookup := minio.BucketLookupAuto
+	if pathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        cred,
+		Secure:       !insecure,
+		Region:       q.Get("region"),
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating s3 client: %w", err)
+	}
+
+	putOpts := minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		StorageClass: q.Get("storage-class"),
+		PartSize:     defaultS3PartSize,
+	}
+
+	if v := q.Get("part-size"); v != "" {
+		mib, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || mib < 5 {
+			return nil, fmt.Errorf("invalid part-size %q: expected a number of MiB, at least 5", v)
+		}
+		putOpts.PartSize = mib << 20
+	}
+
+	switch sse := q.Get("sse"); sse {
+	case "":
+	case "AES256":
+		putOpts.ServerSideEncryption = encrypt.NewSSE()
+	case "aws:kms":
+		sseKMS, err := encrypt.NewSSEKMS(q.Get("sse-kms-key-id"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("configuring sse: %w", err)
+		}
+		putOpts.ServerSideEncryption = sseKMS
+	default:
+		return nil, fmt.Errorf("unsupported sse %q (expected AES256 or aws:kms)", sse)
+	}
+
+	var lockDays int
+	if mode := minio.RetentionMode(strings.ToUpper(q.Get("object-lock-mode"))); mode != "" {
+		if !mode.IsValid() {
+			return nil, fmt.Errorf("invalid object-lock-mode %q (expected GOVERNANCE or COMPLIANCE)", mode)
+		}
+		lockDays, err = strconv.Atoi(q.Get("object-lock-days"))
+		if err != nil || lockDays <= 0 {
+			return nil, fmt.Errorf("object-lock-mode requires a positive object-lock-days")
+		}
+		putOpts.Mode = mode
+	}
+
+	return &S3{
+		client:   client,
+		bucket:   bucket,
+		prefix:   strings.Trim(u.Path, "/"),
+		endpoint: endpoint,
+		putOpts:  putOpts,
+		lockDays: lockDays,
+	}, nil
+}
+
+func (s *S3) String() string {
+	return "s3://" + path.Join(s.bucket, s.prefix) + " (" + s.endpoint + ")"
+}
+
+// Returns the object key for an archive name.
+func (s *S3) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+// Put uploads r in a single request when its size is known and fits in one
+// part, and as a multipart upload otherwise. S3 only makes an object
+// visible once the upload completes, so no temp name is needed. The upload
+// is conditional on the name being free; providers that ignore the
+// condition overwrite the object.
+func (s *S3) Put(ctx context.Context, name string, r io.Reader) error {
+	opts := s.putOpts
+	if s.lockDays > 0 {
+		opts.RetainUntilDate = time.Now().AddDate(0, 0, s.lockDays).UTC()
+	}
+
+	var err error
+	if size, ok := readerSize(r); ok && size <= int64(opts.PartSize) {
+		opts.SetMatchETagExcept("*")
+		_, err = s.client.PutObject(ctx, s.bucket, s.key(name), r, size, opts)
+	} else {
+		err = s.putMultipart(ctx, s.key(name), r, opts)
+	}
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusPreconditionFailed {
+			err = fmt.Errorf("%w: %w", err, fs.ErrExist)
+		}
+		return fmt.Errorf("uploading %s: %w", name, err)
+	}
+	return nil
+}
+
+// Uploads r in parts of opts.PartSize with the low-level API. minio-go
+// drops conditional headers before completing its own multipart uploads,
+// but S3 only evaluates them on CompleteMultipartUpload.
+func (s *S3) putMultipart(ctx context.Context, key string, r io.Reader, opts minio.PutObjectOptions) error {
+	core := minio.Core{Client: s.client}
+	uploadID, err := core.NewMultipartUpload(ctx, s.bucket, key, opts)
+	if err != nil {
+		return err
+	}
+
+	parts, err := s.putParts(ctx, core, key, uploadID, r, opts)
+	if err == nil {
+		var complete minio.PutObjectOptions
+		complete.SetMatchETagExcept("*")
+		_, err = core.CompleteMultipartUpload(ctx, s.bucket, key, uploadID, parts, complete)
+	}
+	if err != nil {
+		// Stored parts are billed until the upload is aborted
+		if abortErr := core.AbortMultipartUpload(context.WithoutCancel(ctx), s.bucket, key, uploadID); abortErr != nil {
+			return fmt.Errorf("%w (aborting upload: %v)", err, abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Uploads the parts of a multipart upload, buffering one part at a time.
+func (s *S3) putParts(ctx context.Context, core minio.Core, key, uploadID string, r io.Reader, opts minio.PutObjectOptions) ([]minio.CompletePart, error) {
+	partOpts := minio.PutObjectPartOptions{DisableContentSha256: opts.DisableContentSha256}
+	buf := make([]byte, opts.PartSize)
+
+	var parts []minio.CompletePart
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		// An empty archive still needs one part
+		if n == 0 && number > 1 {
+			return parts, nil
+		}
+
+		part, err := core.PutObjectPart(ctx, s.bucket, key, uploadID, number, bytes.NewReader(buf[:n]), int64(n), partOpts)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		if n < len(buf) {
+			return parts, nil
+		}
+	}
+}
+
+// List returns the objects below the prefix, including nested ones.
+func (s *S3) List(ctx context.Context) ([]Object, error) {
+	listPrefix := ""
+	if s.prefix != "" {
+		listPrefix = s.prefix + "/"
+	}
+
+	var objects []Object
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: listPrefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("listing objects: %w", info.Err)
+		}
+		name := strings.TrimPrefix(info.Key, listPrefix)
+		// Skip markers of empty "directories"
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		objects = append(objects, Object{Name: name, Size: info.Size, ModTime: info.LastModified})
+	}
+
+	return objects, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, s.key(name), minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("deleting %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *S3) Stat(ctx context.Context, name string) (Object, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		if code := minio.ToErrorResponse(err).Code; code == minio.NoSuchKey || code == "NotFound" {
+			return Object{}, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		}
+		return Object{}, fmt.Errorf("stat %s: %w", name, err)
+	}
+	return Object{Name: name, Size: info.Size, ModTime: info.LastModified}, nil
+}
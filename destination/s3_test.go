@@ -0,0 +1,169 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// Starts an in-process fake S3 server with an empty bucket.
+func newFakeS3(t *testing.T, bucket string) string {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket(bucket); err != nil {
+		t.Fatalf("creating bucket: %v", err)
+	}
+	server := httptest.NewServer(conditionalWrites(backend, gofakes3.New(backend).Server()))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// Answers writes with If-None-Match: * of existing objects with 412, like
+// S3, which gofakes3 does not. S3 evaluates the header on PutObject and
+// CompleteMultipartUpload only.
+func conditionalWrites(backend *s3mem.Backend, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		write := (r.Method == http.MethodPut && !q.Has("partNumber")) || (r.Method == http.MethodPost && q.Has("uploadId"))
+		if write && r.Header.Get("If-None-Match") == "*" {
+			bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			if _, err := backend.HeadObject(bucket, key); err == nil {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusPreconditionFailed)
+				io.WriteString(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func openFakeS3(t *testing.T, rawURL string) *S3 {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parsing URL: %v", err)
+	}
+	dest, err := NewS3(u, S3Credentials{AccessKeyID: "test", SecretAccessKey: "test"})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	return dest
+}
+
+func TestS3_PutListStatDelete(t *testing.T) {
+	ctx := context.Background()
+	endpoint := newFakeS3(t, "backups")
+	dest := openFakeS3(t, "s3://backups/vaultwarden?insecure=true&path-style=true&region=us-east-1&endpoint="+endpoint)
+
+	// Larger than one part to exercise multipart uploads
+	data := make([]byte, 11<<20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("generating data: %v", err)
+	}
+	dest.putOpts.PartSize = 5 << 20
+	// The fake does not decode streaming signatures used over plain HTTP
+	dest.putOpts.DisableContentSha256 = true
+
+	if err := dest.Put(ctx, "vaultage-1.tar.age", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := dest.Put(ctx, "vaultage-2.tar.age", strings.NewReader("small")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	objects, err := dest.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 2 || objects[0].Name != "vaultage-1.tar.age" || objects[0].Size != int64(len(data)) {
+		t.Fatalf("unexpected listing: %+v", objects)
+	}
+
+	obj, err := dest.Stat(ctx, "vaultage-2.tar.age")
+	if err != nil || obj.Size != 5 {
+		t.Fatalf("Stat: %+v, %v", obj, err)
+	}
+
+	if err := dest.Delete(ctx, "vaultage-2.tar.age"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := dest.Stat(ctx, "vaultage-2.tar.age"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+}
+
+func TestS3_PutExisting(t *testing.T) {
+	ctx := context.Background()
+	endpoint := newFakeS3(t, "backups")
+	dest := openFakeS3(t, "s3://backups/vaultwarden?insecure=true&path-style=true&region=us-east-1&endpoint="+endpoint)
+	dest.putOpts.PartSize = 5 << 20
+	dest.putOpts.DisableContentSha256 = true
+
+	large := make([]byte, 6<<20)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatalf("generating data: %v", err)
+	}
+	if err := dest.Put(ctx, "vaultage-1.tar.age", strings.NewReader("original")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	tests := map[string]io.Reader{
+		"single request": strings.NewReader("replacement"),
+		"multipart":      bytes.NewReader(large),
+		// Readers of unknown size are always uploaded in parts
+		"unknown size": io.MultiReader(strings.NewReader("replacement")),
+	}
+	for name, r := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := dest.Put(ctx, "vaultage-1.tar.age", r); !errors.Is(err, fs.ErrExist) {
+				t.Fatalf("expected fs.ErrExist for an existing archive, got %v", err)
+			}
+		})
+	}
+
+	obj, err := dest.Stat(ctx, "vaultage-1.tar.age")
+	if err != nil || obj.Size != int64(len("original")) {
+		t.Fatalf("expected the original archive to be kept, got %+v, %v", obj, err)
+	}
+}
+
+func TestNewS3_InvalidOptions(t *testing.T) {
+	for _, rawURL := range []string{
+		"s3:///prefix",
+		"s3://bucket?sse=rot13",
+		"s3://bucket?part-size=1",
+		"s3://bucket?object-lock-mode=GOVERNANCE",
+		"s3://bucket?storage_class=GLACIER",
+	} {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", rawURL, err)
+		}
+		if _, err := NewS3(u, S3Credentials{}); err == nil {
+			t.Errorf("NewS3(%q): expected error", rawURL)
+		}
+	}
+}
+
+func TestS3_NestedNames(t *testing.T) {
+	endpoint := newFakeS3(t, "backups")
+	dest := openFakeS3(t, "s3://backups/vaultwarden?insecure=true&path-style=true&region=us-east-1&endpoint="+endpoint)
+	dest.putOpts.DisableContentSha256 = true
+
+	testNestedNames(t, dest)
+}
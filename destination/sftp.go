@@ -0,0 +1,308 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPCredentials configure key-based authentication for SFTP destinations.
+type SFTPCredentials struct {
+	// KeyFile is a private key used in addition to the SSH agent.
+	KeyFile string
+	// KeyPassphrase decrypts KeyFile if it is encrypted.
+	KeyPassphrase string
+}
+
+// SFTP stores archives in a directory on a remote host over SSH.
+// A new connection is made for every operation, which keeps long-running
+// watchers independent of idle timeouts on the server.
+type SFTP struct {
+	addr   string
+	dir    string
+	config *ssh.ClientConfig
+}
+
+// NewSFTP returns a destination for a URL of the form
+//
+//	sftp://user@host:port/path?key=/path/to/id_ed25519&known-hosts=/path/to/known_hosts
+//
+// Paths are absolute on the remote host, a path starting with /~/ is
+// relative to the login directory. Authentication uses the SSH agent from
+// SSH_AUTH_SOCK and the key file, if any. The host key is always verified
+// against known_hosts, which defaults to ~/.ssh/known_hosts.
+func NewSFTP(u *url.URL, creds SFTPCredentials) (*SFTP, error) {
+	q := u.Query()
+	if err := checkParams(q, "key", "known-hosts"); err != nil {
+		return nil, err
+	}
+
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("sftp destination requires a user: sftp://user@host/path")
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("sftp destination does not support passwords, use key-based auth")
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("sftp destination requires a host")
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+
+	dir := u.Path
+	if rest, ok := strings.CutPrefix(dir, "/~/"); ok {
+		dir = rest
+	}
+	if dir == "" || dir == "/~" {
+		dir = "."
+	}
+
+	keyFile := q.Get("key")
+	if keyFile == "" {
+		keyFile = creds.KeyFile
+	}
+	auth, err := sshAuthMethods(keyFile, creds.KeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	knownHostsFile := q.Get("known-hosts")
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("locating known_hosts: %w", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading known_hosts: %w", err)
+	}
+
+	return &SFTP{
+		addr: net.JoinHostPort(u.Hostname(), port),
+		dir:  dir,
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+		},
+	}, nil
+}
+
+// Collects the SSH agent and key file auth methods that are available.
+func sshAuthMethods(keyFile, passphrase string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if keyFile != "" {
+		pem, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ssh key: %w", err)
+		}
+		var signer ssh.Signer
+		if passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing ssh key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			conn, err := net.Dial("unix", sock)
+			if err != nil {
+				return nil, fmt.Errorf("connecting to ssh agent: %w", err)
+			}
+			defer conn.Close()
+			return agent.NewClient(conn).Signers()
+		}))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("sftp destination requires an ssh key file or a running ssh agent")
+	}
+	return methods, nil
+}
+
+func (s *SFTP) String() string {
+	return "sftp://" + s.config.User + "@" + s.addr + "/" + strings.TrimPrefix(s.dir, "/")
+}
+
+// Connects to the server and runs fn with an SFTP client.
+func (s *SFTP) withClient(ctx context.Context, fn func(*sftp.Client) error) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", s.addr, err)
+	}
+
+	// Abort the handshake and any transfer when the context is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.addr, s.config)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("ssh handshake with %s: %w", s.addr, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("starting sftp session: %w", err)
+	}
+	defer sftpClient.Close()
+
+	return fn(sftpClient)
+}
+
+// Put uploads r to a hidden temp name and moves it to name, see finalize.
+// Put never replaces an existing file, it returns an error wrapping
+// fs.ErrExist instead.
+func (s *SFTP) Put(ctx context.Context, name string, r io.Reader) error {
+	return s.withClient(ctx, func(c *sftp.Client) error {
+		finalPath := path.Join(s.dir, name)
+		dir := path.Dir(finalPath)
+		if err := c.MkdirAll(dir); err != nil {
+			return fmt.Errorf("creating remote directory: %w", err)
+		}
+		tmpPath := path.Join(dir, "."+path.Base(finalPath)+".tmp-"+tempSuffix())
+
+		f, err := c.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", tmpPath, err)
+		}
+		if _, err := f.ReadFrom(contextReader{ctx: ctx, r: r}); err != nil {
+			f.Close()
+			c.Remove(tmpPath)
+			return fmt.Errorf("uploading %s: %w", name, err)
+		}
+		if err := f.Close(); err != nil {
+			c.Remove(tmpPath)
+			return fmt.Errorf("closing %s: %w", tmpPath, err)
+		}
+
+		if err := s.finalize(c, tmpPath, finalPath); err != nil {
+			c.Remove(tmpPath)
+			return err
+		}
+		return nil
+	})
+}
+
+// Moves the complete temp file to to without replacing an existing file.
+// A hard link, with the OpenSSH extension, fails when to exists, as does
+// the plain SFTP rename on servers following the protocol. Servers report
+// that as a generic failure, so the target is checked afterwards.
+func (s *SFTP) finalize(c *sftp.Client, from, to string) error {
+	_, hardlink := c.HasExtension("hardlink@openssh.com")
+	var err error
+	if hardlink {
+		err = c.Link(from, to)
+	} else {
+		err = c.Rename(from, to)
+	}
+	if err == nil {
+		if hardlink {
+			c.Remove(from)
+		}
+		return nil
+	}
+	if _, statErr := c.Lstat(to); statErr == nil {
+		err = &fs.PathError{Op: "rename", Path: to, Err: fs.ErrExist}
+	}
+	return fmt.Errorf("renaming %s: %w", from, err)
+}
+
+// List returns the regular files in the remote directory and its
+// subdirectories, skipping hidden files and directories such as
+// in-progress uploads. A missing directory is treated as empty.
+func (s *SFTP) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	err := s.withClient(ctx, func(c *sftp.Client) error {
+		walker := c.Walk(s.dir)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				if walker.Path() == s.dir && errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return fmt.Errorf("reading remote directory: %w", err)
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if walker.Path() == s.dir {
+				continue
+			}
+
+			info := walker.Stat()
+			if strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					walker.SkipDir()
+				}
+				continue
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			name := walker.Path()
+			if root := path.Clean(s.dir); root != "." {
+				name = strings.TrimPrefix(name, root+"/")
+			}
+			objects = append(objects, Object{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// Delete removes the file and the directories it leaves empty.
+func (s *SFTP) Delete(ctx context.Context, name string) error {
+	return s.withClient(ctx, func(c *sftp.Client) error {
+		p := path.Join(s.dir, name)
+		if err := c.Remove(p); err != nil {
+			return fmt.Errorf("deleting %s: %w", name, err)
+		}
+		for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			// Fails once a directory is not empty
+			if c.RemoveDirectory(path.Join(s.dir, dir)) != nil {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SFTP) Stat(ctx context.Context, name string) (Object, error) {
+	var obj Object
+	err := s.withClient(ctx, func(c *sftp.Client) error {
+		info, err := c.Stat(path.Join(s.dir, name))
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", name, err)
+		}
+		obj = Object{Name: name, Size: info.Size(), ModTime: info.ModTime()}
+		return nil
+	})
+	return obj, err
+}
@@ -0,0 +1,222 @@
+package destination
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Starts an in-process SSH server that serves the sftp subsystem from root
+// and accepts only clientKey. Returns the listen address and a known_hosts file.
+func newSFTPServer(t *testing.T, root string, clientKey ssh.PublicKey) (string, string) {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("creating host signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTPConn(conn, config, root)
+		}
+	}()
+
+	addr := listener.Addr().String()
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsPath, []byte(line+"\n"), 0600); err != nil {
+		t.Fatalf("writing known_hosts: %v", err)
+	}
+
+	return addr, knownHostsPath
+}
+
+func serveSFTPConn(conn net.Conn, config *ssh.ServerConfig, root string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(root))
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				server.Close()
+			}
+		}()
+	}
+}
+
+// Writes a fresh ed25519 client key in OpenSSH format.
+func newClientKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("marshaling client key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("writing client key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("converting public key: %v", err)
+	}
+	return keyPath, sshPub
+}
+
+func TestSFTP_PutListStatDelete(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	ctx := context.Background()
+	root := t.TempDir()
+	keyPath, pub := newClientKey(t)
+	addr, knownHostsPath := newSFTPServer(t, root, pub)
+
+	u, err := url.Parse("sftp://backup@" + addr + "/~/vaultwarden?known-hosts=" + url.QueryEscape(knownHostsPath))
+	if err != nil {
+		t.Fatalf("parsing URL: %v", err)
+	}
+	dest, err := NewSFTP(u, SFTPCredentials{KeyFile: keyPath})
+	if err != nil {
+		t.Fatalf("NewSFTP: %v", err)
+	}
+
+	if err := dest.Put(ctx, "vaultage-1.tar.age", strings.NewReader("archive v1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// An existing archive is never replaced
+	if err := dest.Put(ctx, "vaultage-1.tar.age", strings.NewReader("archive v2")); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected fs.ErrExist for an existing archive, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "vaultwarden", "vaultage-1.tar.age"))
+	if err != nil || string(data) != "archive v1" {
+		t.Fatalf("unexpected remote file: %q, %v", data, err)
+	}
+
+	objects, err := dest.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Name != "vaultage-1.tar.age" || objects[0].Size != 10 {
+		t.Fatalf("unexpected listing: %+v", objects)
+	}
+
+	if obj, err := dest.Stat(ctx, "vaultage-1.tar.age"); err != nil || obj.Size != 10 {
+		t.Fatalf("Stat: %+v, %v", obj, err)
+	}
+
+	if err := dest.Delete(ctx, "vaultage-1.tar.age"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := dest.Stat(ctx, "vaultage-1.tar.age"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+}
+
+func TestSFTP_RejectsUnknownHostKey(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	root := t.TempDir()
+	keyPath, pub := newClientKey(t)
+	addr, _ := newSFTPServer(t, root, pub)
+
+	// known_hosts entry for the address with a different key
+	_, otherPub := newClientKey(t)
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, otherPub)
+	if err := os.WriteFile(knownHostsPath, []byte(line+"\n"), 0600); err != nil {
+		t.Fatalf("writing known_hosts: %v", err)
+	}
+
+	u, err := url.Parse("sftp://backup@" + addr + "/~/vaultwarden?known-hosts=" + url.QueryEscape(knownHostsPath))
+	if err != nil {
+		t.Fatalf("parsing URL: %v", err)
+	}
+	dest, err := NewSFTP(u, SFTPCredentials{KeyFile: keyPath})
+	if err != nil {
+		t.Fatalf("NewSFTP: %v", err)
+	}
+
+	if err := dest.Put(context.Background(), "vaultage-1.tar.age", strings.NewReader("archive")); err == nil {
+		t.Fatal("expected host key mismatch error")
+	}
+}
+
+func TestSFTP_NestedNames(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	root := t.TempDir()
+	keyPath, pub := newClientKey(t)
+	addr, knownHostsPath := newSFTPServer(t, root, pub)
+
+	u, err := url.Parse("sftp://backup@" + addr + "/~/vaultwarden?known-hosts=" + url.QueryEscape(knownHostsPath))
+	if err != nil {
+		t.Fatalf("parsing URL: %v", err)
+	}
+	dest, err := NewSFTP(u, SFTPCredentials{KeyFile: keyPath})
+	if err != nil {
+		t.Fatalf("NewSFTP: %v", err)
+	}
+	testNestedNames(t, dest)
+}
@@ -0,0 +1,399 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebDAVCredentials authenticate against a WebDAV server. BearerToken takes
+// precedence over basic auth. The basic auth user comes from the URL.
+type WebDAVCredentials struct {
+	Password    string
+	BearerToken string
+}
+
+// WebDAV stores archives in a collection on a WebDAV server such as Nextcloud.
+type WebDAV struct {
+	client *http.Client
+	// Collection URL, always ending in a slash
+	base     *url.URL
+	user     string
+	password string
+	token    string
+	// Nextcloud chunked upload settings, chunkSize is 0 when disabled
+	chunkSize int64
+	uploadURL *url.URL
+}
+
+// Matches the Nextcloud files endpoint to derive its uploads endpoint.
+const nextcloudFilesPath = "/remote.php/dav/files/"
+
+// NewWebDAV returns a destination for a URL of the form
+//
+//	webdavs://user@host/remote.php/dav/files/user/backups?chunk-size=10
+//
+// webdav:// uses plain HTTP, webdavs:// uses HTTPS. The password may be
+// part of the URL or passed in creds.
+//
+// Archives are uploaded to a hidden temp name and moved into place. With
+// chunk-size (in MiB) set on a Nextcloud files URL, archives are uploaded
+// with Nextcloud's chunked upload protocol instead, which gets around
+// request size limits of reverse proxies.
+func NewWebDAV(u *url.URL, creds WebDAVCredentials) (*WebDAV, error) {
+	q := u.Query()
+	if err := checkParams(q, "chunk-size"); err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("webdav destination requires a host")
+	}
+
+	scheme := "http"
+	if u.Scheme == "webdavs" {
+		scheme = "https"
+	}
+	base := &url.URL{Scheme: scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/"}
+
+	w := &WebDAV{
+		client:   &http.Client{},
+		base:     base,
+		password: creds.Password,
+		token:    creds.BearerToken,
+	}
+	if u.User != nil {
+		w.user = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			w.password = password
+		}
+	}
+
+	if v := q.Get("chunk-size"); v != "" {
+		mib, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || mib <= 0 {
+			return nil, fmt.Errorf("invalid chunk-size %q: expected a positive number of MiB", v)
+		}
+		rest, ok := strings.CutPrefix(base.Path, nextcloudFilesPath)
+		user, _, _ := strings.Cut(rest, "/")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("chunk-size requires a Nextcloud URL ending in %s<user>/<path>", nextcloudFilesPath)
+		}
+		w.chunkSize = mib << 20
+		w.uploadURL = &url.URL{Scheme: scheme, Host: u.Host, Path: "/remote.php/dav/uploads/" + user + "/"}
+	}
+
+	return w, nil
+}
+
+func (w *WebDAV) String() string {
+	scheme := "webdav"
+	if w.base.Scheme == "https" {
+		scheme = "webdavs"
+	}
+	return scheme + "://" + w.base.Host + w.base.Path
+}
+
+// Returns the URL of name relative to base.
+func resolve(base *url.URL, name string) string {
+	return base.ResolveReference(&url.URL{Path: name}).String()
+}
+
+// Sends a WebDAV request and returns the response if its status is one of ok.
+// Other responses are closed and turned into errors.
+func (w *WebDAV) do(ctx context.Context, method, target string, body io.Reader, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	switch {
+	case w.token != "":
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	case w.user != "":
+		req.SetBasicAuth(w.user, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+
+	err = fmt.Errorf("%s %s: %s", method, req.URL.Path, resp.Status)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		err = fmt.Errorf("%w: %w", err, fs.ErrNotExist)
+	case http.StatusPreconditionFailed:
+		// A MOVE with Overwrite: F onto an existing resource
+		err = fmt.Errorf("%w: %w", err, fs.ErrExist)
+	}
+	return nil, err
+}
+
+// Sends a request whose response body is not needed.
+func (w *WebDAV) exec(ctx context.Context, method, target string, body io.Reader, header http.Header, ok ...int) error {
+	resp, err := w.do(ctx, method, target, body, header, ok...)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// Creates the destination collection and the collections name is in. An
+// existing collection answers MKCOL with 405, which is fine.
+func (w *WebDAV) ensureCollections(ctx context.Context, name string) error {
+	collections := []string{""}
+	dir := ""
+	for _, segment := range strings.Split(name, "/")[:strings.Count(name, "/")] {
+		dir += segment + "/"
+		collections = append(collections, dir)
+	}
+
+	for _, c := range collections {
+		err := w.exec(ctx, "MKCOL", resolve(w.base, c), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return fmt.Errorf("creating collection: %w", err)
+		}
+	}
+	return nil
+}
+
+// Put uploads r and atomically finalizes it with a MOVE that does not
+// overwrite an existing archive.
+func (w *WebDAV) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := w.ensureCollections(ctx, name); err != nil {
+		return err
+	}
+
+	if w.chunkSize > 0 {
+		return w.putChunked(ctx, name, r)
+	}
+
+	dir, file := path.Split(name)
+	tmpURL := resolve(w.base, dir+"."+file+"."+tempSuffix()+".part")
+	if err := w.exec(ctx, http.MethodPut, tmpURL, contextReader{ctx: ctx, r: r}, nil,
+		http.StatusOK, http.StatusCreated, http.StatusNoContent); err != nil {
+		w.exec(context.WithoutCancel(ctx), http.MethodDelete, tmpURL, nil, nil, http.StatusNoContent, http.StatusOK)
+		return fmt.Errorf("uploading %s: %w", name, err)
+	}
+
+	if err := w.move(ctx, tmpURL, resolve(w.base, name)); err != nil {
+		w.exec(context.WithoutCancel(ctx), http.MethodDelete, tmpURL, nil, nil, http.StatusNoContent, http.StatusOK)
+		return err
+	}
+	return nil
+}
+
+func (w *WebDAV) move(ctx context.Context, from, to string) error {
+	header := http.Header{"Destination": {to}, "Overwrite": {"F"}}
+	if err := w.exec(ctx, "MOVE", from, nil, header, http.StatusCreated, http.StatusNoContent); err != nil {
+		return fmt.Errorf("finalizing upload: %w", err)
+	}
+	return nil
+}
+
+// Uploads r with Nextcloud's chunked upload protocol (v2): chunks are PUT
+// into a temporary upload collection and assembled by a final MOVE.
+func (w *WebDAV) putChunked(ctx context.Context, name string, r io.Reader) error {
+	id := make([]byte, 16)
+	rand.Read(id)
+	uploadDir := &url.URL{
+		Scheme: w.uploadURL.Scheme,
+		Host:   w.uploadURL.Host,
+		Path:   w.uploadURL.Path + "vaultage-" + hex.EncodeToString(id) + "/",
+	}
+	target := resolve(w.base, name)
+	header := http.Header{"Destination": {target}}
+
+	if err := w.exec(ctx, "MKCOL", uploadDir.String(), nil, header, http.StatusCreated); err != nil {
+		return fmt.Errorf("starting chunked upload: %w", err)
+	}
+
+	err := func() error {
+		buf := make([]byte, w.chunkSize)
+		for chunk := 1; ; chunk++ {
+			n, err := io.ReadFull(contextReader{ctx: ctx, r: r}, buf)
+			if n > 0 {
+				// Chunk names are sortable numbers
+				chunkURL := resolve(uploadDir, fmt.Sprintf("%05d", chunk))
+				if err := w.exec(ctx, http.MethodPut, chunkURL, bytes.NewReader(buf[:n]), header,
+					http.StatusCreated, http.StatusNoContent); err != nil {
+					return fmt.Errorf("uploading chunk %d of %s: %w", chunk, name, err)
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}()
+	if err == nil {
+		err = w.move(ctx, resolve(uploadDir, ".file"), target)
+	}
+	if err != nil {
+		w.exec(context.WithoutCancel(ctx), http.MethodDelete, uploadDir.String(), nil, nil, http.StatusNoContent, http.StatusOK)
+		return err
+	}
+	return nil
+}
+
+// Subset of a PROPFIND multistatus response.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/><d:getlastmodified/><d:resourcetype/></d:prop></d:propfind>`
+
+// An entry of a PROPFIND response.
+type davEntry struct {
+	Object
+	// URL path of the entry, unescaped.
+	path       string
+	collection bool
+}
+
+// Runs PROPFIND on target and returns its entries.
+func (w *WebDAV) propfind(ctx context.Context, target, depth string) ([]davEntry, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml"}}
+	resp, err := w.do(ctx, "PROPFIND", target, strings.NewReader(propfindBody), header, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("decoding PROPFIND response: %w", err)
+	}
+
+	var entries []davEntry
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			// Hrefs are usually absolute paths, but may be full URLs
+			p := r.Href
+			if u, err := url.Parse(r.Href); err == nil {
+				p = u.Path
+			}
+			e := davEntry{path: p, collection: ps.Prop.ResourceType.Collection != nil}
+			e.Name = path.Base(p)
+			e.Size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			e.ModTime, _ = time.Parse(http.TimeFormat, ps.Prop.LastModified)
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// List returns the files in the collection and the collections below it,
+// skipping hidden files and collections such as in-progress uploads. A
+// missing collection is treated as empty.
+func (w *WebDAV) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := w.propfind(ctx, resolve(w.base, dir), "1")
+		if err != nil {
+			if dir == "" && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(strings.TrimSuffix(e.path, "/"), w.base.Path)
+			// Skip the collection itself and anything outside of it
+			if !ok || name == strings.TrimSuffix(dir, "/") {
+				continue
+			}
+			if strings.HasPrefix(path.Base(name), ".") {
+				continue
+			}
+			if e.collection {
+				if err := walk(name + "/"); err != nil {
+					return err
+				}
+				continue
+			}
+			e.Name = name
+			objects = append(objects, e.Object)
+		}
+		return nil
+	}
+
+	if err := walk(""); err != nil {
+		return nil, fmt.Errorf("listing collection: %w", err)
+	}
+	return objects, nil
+}
+
+// Delete removes the file and the collections it leaves empty.
+func (w *WebDAV) Delete(ctx context.Context, name string) error {
+	if err := w.exec(ctx, http.MethodDelete, resolve(w.base, name), nil, nil, http.StatusNoContent, http.StatusOK); err != nil {
+		return fmt.Errorf("deleting %s: %w", name, err)
+	}
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		// DELETE on a collection is recursive, so check it is empty first
+		entries, err := w.propfind(ctx, resolve(w.base, dir+"/"), "1")
+		if err != nil {
+			break
+		}
+		self := w.base.Path + dir
+		if slices.ContainsFunc(entries, func(e davEntry) bool { return strings.TrimSuffix(e.path, "/") != self }) {
+			break
+		}
+		if w.exec(ctx, http.MethodDelete, resolve(w.base, dir+"/"), nil, nil, http.StatusNoContent, http.StatusOK) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (w *WebDAV) Stat(ctx context.Context, name string) (Object, error) {
+	entries, err := w.propfind(ctx, resolve(w.base, name), "0")
+	if err != nil {
+		return Object{}, fmt.Errorf("stat %s: %w", name, err)
+	}
+	if len(entries) == 0 || entries[0].collection {
+		return Object{}, fmt.Errorf("stat %s: not a file: %w", name, fs.ErrNotExist)
+	}
+	entries[0].Name = name
+	return entries[0].Object, nil
+}
@@ -0,0 +1,221 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// Starts an in-process WebDAV server requiring basic auth.
+// Chunked uploads are assembled on MOVE of .file like Nextcloud does.
+func newWebDAVServer(t *testing.T) (*httptest.Server, webdav.FileSystem) {
+	t.Helper()
+
+	fsys := webdav.NewMemFS()
+	dav := &webdav.Handler{FileSystem: fsys, LockSystem: webdav.NewMemLS()}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "MOVE" && strings.HasSuffix(r.URL.Path, "/.file") {
+			assembleChunks(t, fsys, w, r)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, fsys
+}
+
+// Concatenates the chunks in the upload collection into the Destination file.
+func assembleChunks(t *testing.T, fsys webdav.FileSystem, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	uploadDir := path.Dir(r.URL.Path)
+
+	dir, err := fsys.OpenFile(ctx, uploadDir, os.O_RDONLY, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	infos, _ := dir.Readdir(-1)
+	dir.Close()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	var buf bytes.Buffer
+	for _, info := range infos {
+		f, err := fsys.OpenFile(ctx, path.Join(uploadDir, info.Name()), os.O_RDONLY, 0)
+		if err != nil {
+			t.Errorf("opening chunk: %v", err)
+			return
+		}
+		io.Copy(&buf, f)
+		f.Close()
+	}
+
+	dest, _ := url.Parse(r.Header.Get("Destination"))
+	out, err := fsys.OpenFile(ctx, dest.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Errorf("creating assembled file: %v", err)
+		return
+	}
+	out.Write(buf.Bytes())
+	out.Close()
+	fsys.RemoveAll(ctx, uploadDir)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func openTestWebDAV(t *testing.T, server *httptest.Server, rawPath string) *WebDAV {
+	t.Helper()
+
+	u, err := url.Parse(strings.Replace(server.URL, "http://", "webdav://alice@", 1) + rawPath)
+	if err != nil {
+		t.Fatalf("parsing URL: %v", err)
+	}
+	dest, err := NewWebDAV(u, WebDAVCredentials{Password: "secret"})
+	if err != nil {
+		t.Fatalf("NewWebDAV: %v", err)
+	}
+	return dest
+}
+
+func TestWebDAV_PutListStatDelete(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newWebDAVServer(t)
+	dest := openTestWebDAV(t, server, "/backups")
+
+	if objects, err := dest.List(ctx); err != nil || len(objects) != 0 {
+		t.Fatalf("expected empty listing for missing collection, got %v, %v", objects, err)
+	}
+
+	if err := dest.Put(ctx, "vaultage-1.tar.age", strings.NewReader("archive v1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// An existing archive is never replaced
+	if err := dest.Put(ctx, "vaultage-1.tar.age", strings.NewReader("archive v2")); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected fs.ErrExist for an existing archive, got %v", err)
+	}
+
+	objects, err := dest.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Name != "vaultage-1.tar.age" || objects[0].Size != 10 {
+		t.Fatalf("unexpected listing: %+v", objects)
+	}
+	if objects[0].ModTime.IsZero() {
+		t.Fatal("expected modification time in listing")
+	}
+
+	if obj, err := dest.Stat(ctx, "vaultage-1.tar.age"); err != nil || obj.Size != 10 {
+		t.Fatalf("Stat: %+v, %v", obj, err)
+	}
+
+	if err := dest.Delete(ctx, "vaultage-1.tar.age"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := dest.Stat(ctx, "vaultage-1.tar.age"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist after delete, got %v", err)
+	}
+}
+
+func TestWebDAV_ChunkedUpload(t *testing.T) {
+	ctx := context.Background()
+	server, fsys := newWebDAVServer(t)
+	for _, dir := range []string{"/remote.php", "/remote.php/dav", "/remote.php/dav/files", "/remote.php/dav/files/alice",
+		"/remote.php/dav/uploads", "/remote.php/dav/uploads/alice"} {
+		if err := fsys.Mkdir(ctx, dir, 0755); err != nil {
+			t.Fatalf("creating %s: %v", dir, err)
+		}
+	}
+	dest := openTestWebDAV(t, server, "/remote.php/dav/files/alice/backups?chunk-size=1")
+
+	data := bytes.Repeat([]byte("0123456789"), 250_000)
+	if err := dest.Put(ctx, "vaultage-1.tar.age", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	obj, err := dest.Stat(ctx, "vaultage-1.tar.age")
+	if err != nil || obj.Size != int64(len(data)) {
+		t.Fatalf("Stat: %+v, %v", obj, err)
+	}
+}
+
+func TestWebDAV_RejectsWrongPassword(t *testing.T) {
+	server, _ := newWebDAVServer(t)
+	u, _ := url.Parse(strings.Replace(server.URL, "http://", "webdav://alice:wrong@", 1) + "/backups")
+	dest, err := NewWebDAV(u, WebDAVCredentials{})
+	if err != nil {
+		t.Fatalf("NewWebDAV: %v", err)
+	}
+	if err := dest.Put(context.Background(), "vaultage-1.tar.age", strings.NewReader("archive")); err == nil {
+		t.Fatal("expected authentication error")
+	}
+}
+
+func TestWebDAV_NestedNames(t *testing.T) {
+	server, _ := newWebDAVServer(t)
+	testNestedNames(t, openTestWebDAV(t, server, "/backups"))
+}
+
+func TestWebDAV_DeleteRemovesEmptyCollections(t *testing.T) {
+	ctx := context.Background()
+	server, fsys := newWebDAVServer(t)
+	dest := openTestWebDAV(t, server, "/backups")
+
+	for _, name := range []string{"2026/10/vaultage-1.tar.age", "2026/11/vaultage-2.tar.age", "2026/12/vaultage-3.tar.age"} {
+		if err := dest.Put(ctx, name, strings.NewReader("archive")); err != nil {
+			t.Fatalf("Put %s: %v", name, err)
+		}
+	}
+	// An upload in progress keeps its collection
+	f, err := fsys.OpenFile(ctx, "/backups/2026/12/.vaultage-4.tar.age.part", os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	exists := func(name string) bool {
+		_, err := fsys.Stat(ctx, name)
+		return err == nil
+	}
+
+	if err := dest.Delete(ctx, "2026/10/vaultage-1.tar.age"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if exists("/backups/2026/10") || !exists("/backups/2026") {
+		t.Fatal("expected only the emptied collection 2026/10 to be removed")
+	}
+
+	if err := dest.Delete(ctx, "2026/11/vaultage-2.tar.age"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := dest.Delete(ctx, "2026/12/vaultage-3.tar.age"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if exists("/backups/2026/11") || !exists("/backups/2026/12/.vaultage-4.tar.age.part") {
+		t.Fatal("expected 2026/11 to be removed and 2026/12 to be kept")
+	}
+
+	if err := dest.Delete(ctx, "2026/12/.vaultage-4.tar.age.part"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	// The destination collection itself is kept
+	if exists("/backups/2026") || !exists("/backups") {
+		t.Fatal("expected the collections below the base to be removed and the base kept")
+	}
+}
@@ -0,0 +1,435 @@
+// Package doctor diagnoses the environment vaultage runs in, such as the
+// Vaultwarden data directory, the output directory, the encryption
+// settings and the clock.
+package doctor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"filippo.io/age"
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/destination"
+	"github.com/mijolabs/vaultage/logging"
+)
+
+// Level is the outcome of a check.
+type Level string
+
+const (
+	Pass Level = "pass"
+	Warn Level = "warn"
+	Fail Level = "fail"
+)
+
+// Check is the outcome of one diagnostic.
+type Check struct {
+	Name   string `json:"name"`
+	Level  Level  `json:"level"`
+	Detail string `json:"detail"`
+}
+
+// Report lists the checks in the order they ran.
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+// Count returns the number of checks with the given level.
+func (r Report) Count(level Level) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Level == level {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) add(name string, level Level, format string, args ...any) {
+	r.Checks = append(r.Checks, Check{Name: name, Level: level, Detail: fmt.Sprintf(format, args...)})
+}
+
+const (
+	// Names of the Vaultwarden files that are checked.
+	dbFileName         = "db.sqlite3"
+	walFileName        = "db.sqlite3-wal"
+	attachmentsDirName = "attachments"
+	configFileName     = "config.json"
+	// Length of the SQLite database header.
+	sqliteHeaderSize = 100
+	// Passphrases shorter than this are reported as weak.
+	minPassphraseLength = 12
+	// Difference between the clock and file times that is tolerated, for
+	// filesystems with coarse timestamps or slightly skewed NFS servers.
+	clockTolerance = time.Minute
+)
+
+// Magic string the SQLite database header starts with.
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// Run checks the environment of a backup with cfg. Checks that depend on
+// an earlier failed one are skipped.
+func Run(cfg backup.Config) Report {
+	var r Report
+	d := r.checkDataDir(cfg)
+	r.checkOutput(cfg, d.archiveSize)
+	r.checkEncryption(cfg)
+	r.checkClock(cfg, d.dbModTime)
+	return r
+}
+
+// What the data directory checks found out, for later checks.
+type dataDirInfo struct {
+	// Estimated size of the next archive, 0 if unknown.
+	archiveSize int64
+	dbModTime   time.Time
+}
+
+func (r *Report) checkDataDir(cfg backup.Config) dataDirInfo {
+	var info dataDirInfo
+
+	dirInfo, err := os.Stat(cfg.DataDir)
+	if err != nil {
+		r.add("data directory", Fail, "%v", err)
+		return info
+	}
+	if !dirInfo.IsDir() {
+		r.add("data directory", Fail, "%s is not a directory", cfg.DataDir)
+		return info
+	}
+
+	dbPath := filepath.Join(cfg.DataDir, dbFileName)
+	header, dbInfo, err := readDatabaseHeader(dbPath)
+	if err != nil {
+		r.add("database", Fail, "%v", err)
+		return info
+	}
+	r.add("database", Pass, "%s is readable, %s", dbPath, logging.FormatSize(dbInfo.Size()))
+	info.archiveSize = dbInfo.Size()
+	info.dbModTime = dbInfo.ModTime()
+
+	// Bytes 18 and 19 hold the file format versions, 2 for WAL
+	if header[18] == 2 {
+		r.add("journal mode", Pass, "wal")
+	} else {
+		r.add("journal mode", Warn, "rollback journal, watch mode only notices changes in WAL mode")
+	}
+
+	walInfo, err := os.Stat(filepath.Join(cfg.DataDir, walFileName))
+	switch {
+	case err == nil:
+		r.add("wal file", Pass, "%s, %s", walFileName, logging.FormatSize(walInfo.Size()))
+		info.archiveSize += walInfo.Size()
+		if walInfo.ModTime().After(info.dbModTime) {
+			info.dbModTime = walInfo.ModTime()
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		r.add("wal file", Warn, "%s not present, which is normal while Vaultwarden is stopped", walFileName)
+	default:
+		r.add("wal file", Fail, "%v", err)
+	}
+
+	r.checkWatch(cfg.DataDir)
+	r.checkMount(cfg)
+
+	if !cfg.ExcludeAttachments {
+		size, ok := r.checkAttachments(filepath.Join(cfg.DataDir, attachmentsDirName))
+		if !ok {
+			return dataDirInfo{dbModTime: info.dbModTime}
+		}
+		info.archiveSize += size
+	}
+	if !cfg.ExcludeConfigFile {
+		if configInfo, err := os.Stat(filepath.Join(cfg.DataDir, configFileName)); err == nil {
+			info.archiveSize += configInfo.Size()
+		}
+	}
+	return info
+}
+
+// Reads the header of the SQLite database at path.
+func readDatabaseHeader(path string) ([]byte, fs.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, sqliteHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.HasPrefix(header, sqliteMagic) {
+		return nil, nil, fmt.Errorf("%s is not an SQLite database", path)
+	}
+	return header, info, nil
+}
+
+// Checks that the data directory can be watched, which can fail when the
+// inotify limits are exhausted, and warns about network filesystems that
+// do not report changes made elsewhere.
+func (r *Report) checkWatch(dir string) {
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = w.Add(dir)
+		w.Close()
+	}
+	if err != nil {
+		r.add("file watching", Fail, "%v, check fs.inotify.max_user_watches and max_user_instances", err)
+		return
+	}
+
+	fsType, remote, err := filesystemType(dir)
+	switch {
+	case err != nil:
+		r.add("file watching", Pass, "watching %s works", dir)
+	case remote:
+		r.add("file watching", Warn, "%s is on %s, changes made by other hosts raise no events, "+
+			"use --backup-on-start or a scheduled backup", dir, fsType)
+	default:
+		r.add("file watching", Pass, "watching %s works on %s", dir, fsType)
+	}
+}
+
+// Reports whether the data directory is mounted read-only, which rules
+// out the online snapshot.
+func (r *Report) checkMount(cfg backup.Config) {
+	if isWritable(cfg.DataDir) {
+		r.add("mount", Pass, "read-write")
+		return
+	}
+	if cfg.Snapshot == backup.SnapshotOnline {
+		r.add("mount", Fail, "read-only, but --snapshot-mode online needs to write %s-shm, use auto or copy", dbFileName)
+		return
+	}
+	r.add("mount", Pass, "read-only, the database is snapshotted from a copy")
+}
+
+// Checks that all attachments can be read and returns their total size.
+func (r *Report) checkAttachments(dir string) (int64, bool) {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		r.add("attachments", Pass, "no attachments directory")
+		return 0, true
+	}
+
+	var size int64
+	var files int
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		files++
+		return nil
+	})
+	if err != nil {
+		r.add("attachments", Fail, "%v", err)
+		return 0, false
+	}
+	r.add("attachments", Pass, "%d files, %s", files, logging.FormatSize(size))
+	return size, true
+}
+
+// Checks that local output directories are writable and have room for
+// the next archive of about archiveSize bytes.
+func (r *Report) checkOutput(cfg backup.Config, archiveSize int64) {
+	for _, t := range cfg.OutputTargets() {
+		local, ok := t.Destination.(*destination.Local)
+		if !ok {
+			r.add("destination", Pass, "%s is remote, check access with vaultage config check", t.Destination)
+			continue
+		}
+		dir := local.Dir()
+
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			parent := existingParent(dir)
+			if !isWritable(parent) {
+				r.add("output directory", Fail, "%s does not exist and cannot be created in %s", dir, parent)
+				continue
+			}
+			r.add("output directory", Warn, "%s does not exist yet, it is created on the first backup", dir)
+			dir = parent
+		} else if err := probeWrite(dir); err != nil {
+			r.add("output directory", Fail, "%s is not writable: %v", dir, err)
+			continue
+		} else {
+			r.add("output directory", Pass, "%s is writable", dir)
+		}
+
+		free, err := freeSpace(dir)
+		switch {
+		case err != nil || archiveSize == 0:
+			// Unknown on this platform, or the data directory failed
+		case free < uint64(archiveSize):
+			r.add("free space", Fail, "%s free in %s, the next archive needs about %s",
+				logging.FormatSize(int64(free)), dir, logging.FormatSize(archiveSize))
+		case free < 2*uint64(archiveSize):
+			r.add("free space", Warn, "%s free in %s, room for only one archive of about %s",
+				logging.FormatSize(int64(free)), dir, logging.FormatSize(archiveSize))
+		default:
+			r.add("free space", Pass, "%s free in %s, archives are about %s",
+				logging.FormatSize(int64(free)), dir, logging.FormatSize(archiveSize))
+		}
+	}
+}
+
+// Returns dir or its closest ancestor that exists.
+func existingParent(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// Creates and removes a hidden file in dir.
+func probeWrite(dir string) error {
+	f, err := os.CreateTemp(dir, ".vaultage-doctor-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Checks that the passphrase or key file encrypts and decrypts a probe.
+func (r *Report) checkEncryption(cfg backup.Config) {
+	switch {
+	case cfg.WithoutEncryption:
+		r.add("encryption", Warn, "disabled, archives are written unencrypted")
+	case cfg.AgePassphrase != "" && cfg.AgeKeyFile != "":
+		r.add("encryption", Fail, "--age-passphrase and --age-key-file are mutually exclusive")
+	case cfg.AgePassphrase != "":
+		recipient, err := age.NewScryptRecipient(cfg.AgePassphrase)
+		if err == nil {
+			var identity *age.ScryptIdentity
+			identity, err = age.NewScryptIdentity(cfg.AgePassphrase)
+			if err == nil {
+				err = probeEncryption([]age.Recipient{recipient}, identity)
+			}
+		}
+		switch {
+		case err != nil:
+			r.add("encryption", Fail, "passphrase: %v", err)
+		case len(cfg.AgePassphrase) < minPassphraseLength:
+			r.add("encryption", Warn, "passphrase works but is shorter than %d characters", minPassphraseLength)
+		default:
+			r.add("encryption", Pass, "passphrase encrypts and decrypts a probe")
+		}
+	case cfg.AgeKeyFile != "":
+		level, detail := CheckKeyFile(cfg.AgeKeyFile)
+		r.add("encryption", level, "%s", detail)
+	default:
+		r.add("encryption", Warn, "no passphrase or key file, backup prompts for one and watch does not start")
+	}
+}
+
+// CheckKeyFile checks an age key file by encrypting a probe to its
+// recipients, as backups do, and decrypting it again with its identities,
+// if any. vaultage config check shares it, so both report the same verdict.
+func CheckKeyFile(path string) (Level, string) {
+	recipients, err := backup.KeyFileRecipients(path)
+	if err != nil {
+		return Fail, err.Error()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Fail, fmt.Sprintf("key file: %v", err)
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		// Recipients only, so the archives cannot be decrypted on this host
+		if err := probeEncryption(recipients); err != nil {
+			return Fail, fmt.Sprintf("key file %s: %v", path, err)
+		}
+		return Pass, fmt.Sprintf("%s encrypts a probe, it holds no identities to check decryption with", path)
+	}
+	if err := probeEncryption(recipients, identities...); err != nil {
+		return Fail, fmt.Sprintf("key file %s: %v", path, err)
+	}
+	return Pass, fmt.Sprintf("%s encrypts and decrypts a probe", path)
+}
+
+// Encrypts a probe to the recipients and decrypts it with identities.
+// Without identities only encryption is checked.
+func probeEncryption(recipients []age.Recipient, identities ...age.Identity) error {
+	probe := []byte("vaultage doctor probe")
+
+	buf := &bytes.Buffer{}
+	w, err := age.Encrypt(buf, recipients...)
+	if err != nil {
+		return fmt.Errorf("encrypting probe: %w", err)
+	}
+	if _, err := w.Write(probe); err != nil {
+		return fmt.Errorf("encrypting probe: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("encrypting probe: %w", err)
+	}
+	if len(identities) == 0 {
+		return nil
+	}
+
+	rd, err := age.Decrypt(buf, identities...)
+	if err != nil {
+		return fmt.Errorf("decrypting probe: %w", err)
+	}
+	got, err := io.ReadAll(rd)
+	if err != nil {
+		return fmt.Errorf("decrypting probe: %w", err)
+	}
+	if !bytes.Equal(got, probe) {
+		return errors.New("decrypted probe does not match")
+	}
+	return nil
+}
+
+// Checks that the clock is not behind the database and the run history,
+// which breaks retention and the age checks of the watcher.
+func (r *Report) checkClock(cfg backup.Config, dbModTime time.Time) {
+	now := time.Now()
+
+	if dbModTime.After(now.Add(clockTolerance)) {
+		r.add("clock", Fail, "the database was modified %s in the future, the clock is behind",
+			dbModTime.Sub(now).Round(time.Second))
+		return
+	}
+	if cfg.History != nil {
+		records, err := cfg.History.Records()
+		if err == nil && len(records) > 0 {
+			last := records[len(records)-1].Started
+			if last.After(now.Add(clockTolerance)) {
+				r.add("clock", Fail, "the last backup started %s in the future, the clock went backwards",
+					last.Sub(now).Round(time.Second))
+				return
+			}
+		}
+	}
+
+	zone, _ := now.Zone()
+	r.add("clock", Pass, "%s, time zone %s", now.Format(time.DateTime), zone)
+}
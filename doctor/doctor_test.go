@@ -0,0 +1,152 @@
+package doctor
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	_ "modernc.org/sqlite"
+
+	"github.com/mijolabs/vaultage/backup"
+)
+
+// Creates a data directory with a WAL database that is held open, so the
+// WAL file stays in place.
+func newDataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	db, err := sql.Open("sqlite", filepath.Join(dir, dbFileName))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`PRAGMA journal_mode=WAL; CREATE TABLE t (a); INSERT INTO t VALUES (1);`); err != nil {
+		t.Fatalf("creating test data: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, attachmentsDirName, "cipher"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, attachmentsDirName, "cipher", "file"), []byte("attachment"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// Returns the check with the given name, failing the test if it is missing.
+func findCheck(t *testing.T, r Report, name string) Check {
+	t.Helper()
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("no %q check in %+v", name, r.Checks)
+	return Check{}
+}
+
+func TestRun(t *testing.T) {
+	cfg := backup.Config{
+		DataDir:       newDataDir(t),
+		OutputDir:     t.TempDir(),
+		AgePassphrase: "correct horse battery staple",
+	}
+	r := Run(cfg)
+
+	for _, c := range r.Checks {
+		if c.Level != Pass {
+			t.Errorf("%s: %s: %s", c.Name, c.Level, c.Detail)
+		}
+	}
+	if c := findCheck(t, r, "journal mode"); c.Detail != "wal" {
+		t.Errorf("journal mode = %q, want wal", c.Detail)
+	}
+	if c := findCheck(t, r, "attachments"); c.Detail != "1 files, 10 B" {
+		t.Errorf("attachments = %q", c.Detail)
+	}
+}
+
+func TestRun_MissingDataDir(t *testing.T) {
+	cfg := backup.Config{
+		DataDir:           filepath.Join(t.TempDir(), "missing"),
+		OutputDir:         t.TempDir(),
+		WithoutEncryption: true,
+	}
+	r := Run(cfg)
+
+	if c := findCheck(t, r, "data directory"); c.Level != Fail {
+		t.Errorf("data directory = %s, want fail", c.Level)
+	}
+	if c := findCheck(t, r, "encryption"); c.Level != Warn {
+		t.Errorf("encryption = %s, want warn", c.Level)
+	}
+	if r.Count(Fail) != 1 {
+		t.Errorf("%d failed checks, want 1", r.Count(Fail))
+	}
+}
+
+func TestRun_OutputDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		want   Level
+	}{
+		{"missing", filepath.Join(dir, "backups"), Warn},
+		{"under a file", filepath.Join(file, "backups"), Fail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Run(backup.Config{DataDir: newDataDir(t), OutputDir: tt.output, WithoutEncryption: true})
+			if c := findCheck(t, r, "output directory"); c.Level != tt.want {
+				t.Fatalf("output directory = %s (%s), want %s", c.Level, c.Detail, tt.want)
+			}
+			if _, err := os.Stat(tt.output); err == nil {
+				t.Fatalf("%s was created", tt.output)
+			}
+		})
+	}
+}
+
+func TestCheckKeyFile(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want Level
+	}{
+		{"identity", write("key.txt", "# created: now\n"+identity.String()+"\n"), Pass},
+		// Backups only need the public key
+		{"recipient only", write("recipient.txt", identity.Recipient().String()+"\n"), Pass},
+		{"garbage", write("garbage.txt", "not a key\n"), Fail},
+		{"missing", filepath.Join(dir, "missing.txt"), Fail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, detail := CheckKeyFile(tt.path); got != tt.want {
+				t.Fatalf("CheckKeyFile = %s (%s), want %s", got, detail, tt.want)
+			}
+		})
+	}
+}
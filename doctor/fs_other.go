@@ -0,0 +1,17 @@
+//go:build !unix
+
+package doctor
+
+import "errors"
+
+// Reports whether the current process may write to path.
+// Non-unix platforms have no cheap check, so paths are assumed writable.
+func isWritable(path string) bool {
+	return true
+}
+
+// Returns the bytes available on the filesystem holding path.
+// Not supported on non-unix platforms.
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package doctor
+
+import "golang.org/x/sys/unix"
+
+// Reports whether the current process may write to path.
+func isWritable(path string) bool {
+	return unix.Access(path, unix.W_OK) == nil
+}
+
+// Returns the bytes available to unprivileged users on the filesystem
+// holding path.
+func freeSpace(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
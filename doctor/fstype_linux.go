@@ -0,0 +1,46 @@
+package doctor
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystems on which inotify only sees changes made through the local
+// mount, by their statfs magic numbers.
+var remoteFilesystems = map[uint32]string{
+	unix.NFS_SUPER_MAGIC:  "NFS",
+	unix.SMB_SUPER_MAGIC:  "SMB",
+	unix.CIFS_SUPER_MAGIC: "CIFS",
+	unix.SMB2_SUPER_MAGIC: "SMB2",
+	unix.FUSE_SUPER_MAGIC: "FUSE",
+	unix.V9FS_MAGIC:       "9p",
+	unix.CEPH_SUPER_MAGIC: "Ceph",
+}
+
+// Returns the filesystem type of path and whether it is a network or
+// FUSE filesystem.
+func filesystemType(path string) (string, bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", false, err
+	}
+	if name, ok := remoteFilesystems[uint32(st.Type)]; ok {
+		return name, true, nil
+	}
+	switch uint32(st.Type) {
+	case unix.EXT4_SUPER_MAGIC:
+		return "ext4", false, nil
+	case unix.XFS_SUPER_MAGIC:
+		return "XFS", false, nil
+	case unix.BTRFS_SUPER_MAGIC:
+		return "Btrfs", false, nil
+	case 0x2fc12fc1: // ZFS, which has no constant in x/sys
+		return "ZFS", false, nil
+	case unix.OVERLAYFS_SUPER_MAGIC:
+		return "overlayfs", false, nil
+	case unix.TMPFS_MAGIC:
+		return "tmpfs", false, nil
+	}
+	return fmt.Sprintf("filesystem 0x%x", st.Type), false, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package doctor
+
+import "errors"
+
+// Returns the filesystem type of path and whether it is a network or
+// FUSE filesystem. Only supported on Linux.
+func filesystemType(path string) (string, bool, error) {
+	return "", false, errors.ErrUnsupported
+}
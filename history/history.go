@@ -0,0 +1,181 @@
+// Package history keeps an append-only journal of backup runs.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mijolabs/vaultage/logging"
+)
+
+// Trigger names what started a backup run.
+type Trigger string
+
+const (
+	// TriggerWAL is a backup after changes to the database WAL file.
+	TriggerWAL Trigger = "wal"
+	// TriggerManual is a one-shot backup started with vaultage backup.
+	TriggerManual Trigger = "manual"
+	// TriggerStartup is the backup taken when the watcher starts.
+	TriggerStartup Trigger = "startup"
+	// TriggerRetry is a retry of a failed backup.
+	TriggerRetry Trigger = "retry"
+	// TriggerRecovery is the safety backup after the watch on the data
+	// directory was re-established.
+	TriggerRecovery Trigger = "recovery"
+)
+
+// Results of a run.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record describes one backup run.
+type Record struct {
+	RunID string `json:"run_id"`
+	// Instance names the Vaultwarden instance when several are configured.
+	Instance string    `json:"instance,omitempty"`
+	Trigger  Trigger   `json:"trigger,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Result   string    `json:"result"`
+	// Stage is the step that failed, see backup.Stage.
+	Stage   string `json:"stage,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Archive string `json:"archive,omitempty"`
+	// Size is the archive size in bytes, after encryption.
+	Size int64 `json:"size,omitempty"`
+	// SHA256 is the hex encoded digest of the archive as stored.
+	SHA256 string `json:"sha256,omitempty"`
+	// DatabaseSHA256 is the hex encoded digest of the database snapshot,
+	// which tells whether the data changed since the run.
+	DatabaseSHA256 string        `json:"database_sha256,omitempty"`
+	Destinations   []Destination `json:"destinations,omitempty"`
+}
+
+// Destination reports what happened at one target of a run.
+type Destination struct {
+	Name string `json:"name"`
+	// Archive is set when the archive was stored under a different name
+	// than Record.Archive, because that name was taken.
+	Archive string   `json:"archive,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Spooled bool     `json:"spooled,omitempty"`
+	Pruned  []string `json:"pruned,omitempty"`
+}
+
+// Succeeded reports whether the run succeeded.
+func (r Record) Succeeded() bool {
+	return r.Result == ResultSuccess
+}
+
+// Journal is a JSON Lines file with one record per backup run. Records are
+// only ever appended, so the file doubles as an audit trail.
+type Journal struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Name of the journal file inside the state directory.
+const fileName = "history.jsonl"
+
+// Open returns the journal in dir. The directory is only created by
+// Append, reading a journal that does not exist yet returns no records.
+func Open(dir string) *Journal {
+	return &Journal{path: filepath.Join(dir, fileName)}
+}
+
+// Path returns the journal file.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Append adds r to the journal and syncs it to disk, creating the
+// directory if needed.
+func (j *Journal) Append(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encoding history record: %w", err)
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return fmt.Errorf("creating history directory: %w", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("opening history: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("writing history: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("writing history: %w", err)
+	}
+	return f.Close()
+}
+
+// Records returns all records, oldest first. Lines that cannot be parsed,
+// such as one cut short by a crash, are skipped with a warning.
+func (j *Journal) Records() ([]Record, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening history: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			slog.Warn("skipping malformed history record", "path", j.path, "line", n, logging.Err(err))
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	return records, nil
+}
+
+// LastSuccess returns the most recent successful run.
+// The bool result is false when no run succeeded yet.
+func (j *Journal) LastSuccess() (Record, bool, error) {
+	records, err := j.Records()
+	if err != nil {
+		return Record{}, false, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Succeeded() {
+			return records[i], true, nil
+		}
+	}
+	return Record{}, false, nil
+}
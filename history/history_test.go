@@ -0,0 +1,59 @@
+package history
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal_AppendAndRead(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	j := Open(dir)
+
+	if _, ok, err := j.LastSuccess(); ok || err != nil {
+		t.Fatalf("expected no success in empty journal, got %t, %v", ok, err)
+	}
+
+	started := time.Date(2026, 10, 18, 2, 0, 0, 0, time.UTC)
+	records := []Record{
+		{RunID: "a", Trigger: TriggerStartup, Started: started, Finished: started.Add(time.Second), Result: ResultSuccess, Archive: "vaultage-1.tar.age", Size: 42, SHA256: "abc"},
+		{RunID: "b", Trigger: TriggerWAL, Started: started.Add(time.Hour), Finished: started.Add(time.Hour), Result: ResultFailure, Stage: "upload", Error: "connection refused",
+			Destinations: []Destination{{Name: "s3://bucket", Error: "connection refused"}}},
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected Open and reading not to create the directory, got %v", err)
+	}
+	for _, r := range records {
+		if err := j.Append(r); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	// A record cut short by a crash is skipped
+	f, err := os.OpenFile(j.Path(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("opening journal: %v", err)
+	}
+	f.WriteString(`{"run_id":"c","tri`)
+	f.Close()
+
+	j = Open(dir)
+	got, err := j.Records()
+	if err != nil {
+		t.Fatalf("Records: %v", err)
+	}
+	if len(got) != 2 || got[0].RunID != "a" || got[1].RunID != "b" {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+	if got[1].Destinations[0].Error != "connection refused" || !got[0].Started.Equal(started) {
+		t.Fatalf("record not round-tripped: %+v", got)
+	}
+
+	last, ok, err := j.LastSuccess()
+	if err != nil || !ok || last.RunID != "a" {
+		t.Fatalf("expected last success a, got %+v, %t, %v", last, ok, err)
+	}
+}
@@ -0,0 +1,126 @@
+// Package logging configures structured logging with log/slog.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Formats accepted by New.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a logger writing records at or above level to w, as logfmt
+// style text or as JSON. Attributes added with With are included in
+// records logged with a context, and values of attributes whose keys look
+// like secrets are replaced. In JSON, durations are written as seconds.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: expected debug, info, warn or error", level)
+	}
+
+	var h slog.Handler
+	switch format {
+	case FormatText:
+		h = slog.NewTextHandler(w, &slog.HandlerOptions{Level: lvl, ReplaceAttr: redact})
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: lvl,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Value.Kind() == slog.KindDuration {
+					a.Value = slog.Float64Value(a.Value.Duration().Seconds())
+				}
+				return redact(groups, a)
+			},
+		})
+	default:
+		return nil, fmt.Errorf("invalid log format %q: expected text or json", format)
+	}
+
+	return slog.New(contextHandler{h}), nil
+}
+
+// Err returns the attribute for an error, under the key "error".
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+type contextKey struct{}
+
+// With returns a context whose log records carry the given attributes,
+// in the same key-value form as slog.Logger.With.
+func With(ctx context.Context, args ...any) context.Context {
+	attrs, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	r := slog.Record{}
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, contextKey{}, attrs[:len(attrs):len(attrs)])
+}
+
+// NewRunID returns a random ID that ties together the records of one backup run.
+func NewRunID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FormatSize returns a human-readable size such as 1.5 MB, for log
+// messages, reports and notifications.
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGT"[exp])
+}
+
+// contextHandler adds the attributes stored by With to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Parts of attribute keys whose values are never logged.
+var secretKeys = []string{"passphrase", "password", "secret", "token", "authorization"}
+
+// Replaces the values of attributes that look like secrets, as a safety
+// net in case one is ever logged by mistake.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return slog.String(a.Key, "[REDACTED]")
+		}
+	}
+	return a
+}
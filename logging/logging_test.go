@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, "info")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx := With(context.Background(), "run_id", "abc123")
+	logger.DebugContext(ctx, "hidden")
+	logger.InfoContext(ctx, "backup written", "bytes", 42, "duration", 1500*time.Millisecond,
+		"age_passphrase", "hunter2", Err(errors.New("boom")))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["run_id"] != "abc123" || record["bytes"] != 42.0 || record["duration"] != 1.5 || record["error"] != "boom" {
+		t.Fatalf("unexpected record: %v", record)
+	}
+	if record["age_passphrase"] != "[REDACTED]" || strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("secret was logged: %s", buf.String())
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Fatal("expected error for invalid format")
+	}
+	if _, err := New(&bytes.Buffer{}, FormatText, "verbose"); err == nil {
+		t.Fatal("expected error for invalid level")
+	}
+}
@@ -0,0 +1,198 @@
+// Package metrics exposes backup state in the Prometheus exposition format.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/watcher"
+)
+
+// Registry serves the metrics of one or more watched instances.
+type Registry struct {
+	registry *prometheus.Registry
+}
+
+// NewRegistry returns an empty Registry with the Go runtime and process
+// collectors.
+func NewRegistry() *Registry {
+	r := &Registry{registry: prometheus.NewRegistry()}
+	r.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return r
+}
+
+// Handler serves the metrics of all instances.
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{})
+}
+
+// Name of the label telling instances apart, see Registry.Instance.
+const instanceLabel = "vault"
+
+// Metrics holds the collectors updated from backup runs and watcher status.
+type Metrics struct {
+	registry *Registry
+
+	lastSuccess      prometheus.Gauge
+	lastAttempt      prometheus.Gauge
+	lastResult       prometheus.Gauge
+	duration         prometheus.Histogram
+	archiveSize      prometheus.Gauge
+	databaseSize     prometheus.Gauge
+	attachments      prometheus.Gauge
+	runs             *prometheus.CounterVec
+	failures         *prometheus.CounterVec
+	pending          prometheus.Gauge
+	retryAttempt     prometheus.Gauge
+	retentionDeleted *prometheus.CounterVec
+}
+
+// New returns Metrics registered with their own registry.
+func New() *Metrics {
+	return NewRegistry().Instance("")
+}
+
+// Instance returns the Metrics of one instance. Unless name is empty, its
+// series carry a vault label with the name.
+func (r *Registry) Instance(name string) *Metrics {
+	m := &Metrics{
+		registry: r,
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vaultage_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful backup.",
+		}),
+		lastAttempt: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vaultage_last_attempt_timestamp_seconds",
+			Help: "Unix time of the last backup attempt.",
+		}),
+		lastResult: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vaultage_last_attempt_success",
+			Help: "Whether the last backup attempt succeeded (1) or failed (0).",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "vaultage_backup_duration_seconds",
+			Help:    "Duration of backup runs, including uploads.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+		}),
+		archiveSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vaultage_archive_size_bytes",
+			Help: "Size of the last archive after encryption.",
+		}),
+		databaseSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vaultage_database_snapshot_size_bytes",
+			Help: "Size of the last database snapshot.",
+		}),
+		attachments: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vaultage_attachments",
+			Help: "Number of attachment files in the last archive.",
+		}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "vaultage_backups_total",
+			Help: "Backup runs by result.",
+		}, []string{"result"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "vaultage_backup_failures_total",
+			Help: "Backup failures by the stage they occurred in. Every failed destination counts once.",
+		}, []string{"stage"}),
+		pending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vaultage_pending_changes",
+			Help: "Whether detected changes are waiting for a backup (1) or not (0).",
+		}),
+		retryAttempt: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vaultage_retry_attempt",
+			Help: "Number of consecutive failed backups.",
+		}),
+		retentionDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "vaultage_retention_deleted_total",
+			Help: "Archives deleted by retention policies, by destination.",
+		}, []string{"destination"}),
+	}
+
+	var reg prometheus.Registerer = r.registry
+	if name != "" {
+		reg = prometheus.WrapRegistererWith(prometheus.Labels{instanceLabel: name}, reg)
+	}
+	reg.MustRegister(
+		m.lastSuccess, m.lastAttempt, m.lastResult, m.duration,
+		m.archiveSize, m.databaseSize, m.attachments,
+		m.runs, m.failures, m.pending, m.retryAttempt, m.retentionDeleted,
+	)
+
+	// Export zero values up front so rate() and absent() based alerts work
+	// before the first failure.
+	for _, result := range []string{"success", "failure"} {
+		m.runs.WithLabelValues(result)
+	}
+	for _, stage := range backup.Stages {
+		m.failures.WithLabelValues(string(stage))
+	}
+
+	return m
+}
+
+// Handler serves the metrics of all instances in the registry.
+func (m *Metrics) Handler() http.Handler {
+	return m.registry.Handler()
+}
+
+// ObserveBackup records the outcome of a backup run.
+func (m *Metrics) ObserveBackup(result backup.Result, err error) {
+	now := float64(time.Now().Unix())
+	m.lastAttempt.Set(now)
+	m.duration.Observe(result.Duration.Seconds())
+
+	if result.DatabaseSize > 0 {
+		m.databaseSize.Set(float64(result.DatabaseSize))
+		m.attachments.Set(float64(result.Attachments))
+	}
+	if result.Size > 0 {
+		m.archiveSize.Set(float64(result.Size))
+	}
+
+	for _, d := range result.Destinations {
+		if stage, ok := backup.ErrorStage(d.Err); ok {
+			m.failures.WithLabelValues(string(stage)).Inc()
+		}
+		if len(d.Pruned) > 0 {
+			m.retentionDeleted.WithLabelValues(d.Destination).Add(float64(len(d.Pruned)))
+		}
+	}
+	// Failures before the upload have no destination results
+	if stage, ok := backup.ErrorStage(err); ok && len(result.Destinations) == 0 {
+		m.failures.WithLabelValues(string(stage)).Inc()
+	}
+
+	if err != nil {
+		m.lastResult.Set(0)
+		m.runs.WithLabelValues("failure").Inc()
+		return
+	}
+	m.lastResult.Set(1)
+	m.lastSuccess.Set(now)
+	m.runs.WithLabelValues("success").Inc()
+}
+
+// ObserveStatus records the watcher state. The last success is taken
+// over from the status, which knows it from the history after a restart.
+func (m *Metrics) ObserveStatus(s watcher.Status) {
+	m.pending.Set(boolValue(s.Unbacked))
+	m.retryAttempt.Set(float64(s.RetryAttempt))
+	if !s.LastSuccess.IsZero() {
+		m.lastSuccess.Set(float64(s.LastSuccess.Unix()))
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/watcher"
+)
+
+func TestObserveBackup(t *testing.T) {
+	m := New()
+
+	m.ObserveBackup(backup.Result{
+		Filename:     "vaultage-20260101_000000.tar.age",
+		Size:         2048,
+		Duration:     3 * time.Second,
+		DatabaseSize: 1024,
+		Attachments:  5,
+		Destinations: []backup.DestinationResult{
+			{Destination: "/backups", Pruned: []string{"a", "b"}},
+		},
+	}, nil)
+
+	if v := testutil.ToFloat64(m.lastResult); v != 1 {
+		t.Fatalf("expected last attempt success 1, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.archiveSize); v != 2048 {
+		t.Fatalf("expected archive size 2048, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.retentionDeleted.WithLabelValues("/backups")); v != 2 {
+		t.Fatalf("expected 2 retention deletions, got %v", v)
+	}
+
+	upload := &backup.StageError{Stage: backup.StageUpload, Err: errors.New("connection refused")}
+	m.ObserveBackup(backup.Result{
+		Size:         2048,
+		Destinations: []backup.DestinationResult{{Destination: "s3://bucket", Err: upload}},
+	}, upload)
+	m.ObserveBackup(backup.Result{}, &backup.StageError{Stage: backup.StageSnapshot, Err: errors.New("locked")})
+
+	if v := testutil.ToFloat64(m.lastResult); v != 0 {
+		t.Fatalf("expected last attempt success 0, got %v", v)
+	}
+	for stage, want := range map[string]float64{"upload": 1, "snapshot": 1, "encrypt": 0} {
+		if v := testutil.ToFloat64(m.failures.WithLabelValues(stage)); v != want {
+			t.Fatalf("expected %v %s failures, got %v", want, stage, v)
+		}
+	}
+	if v := testutil.ToFloat64(m.runs.WithLabelValues("failure")); v != 2 {
+		t.Fatalf("expected 2 failed runs, got %v", v)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.ObserveStatus(watcher.Status{Unbacked: true})
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"vaultage_pending_changes 1",
+		`vaultage_backup_failures_total{stage="write"} 0`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+}
+
+func TestRegistry_Instances(t *testing.T) {
+	r := NewRegistry()
+	r.Instance("family").ObserveStatus(watcher.Status{Unbacked: true})
+	r.Instance("work").ObserveStatus(watcher.Status{RetryAttempt: 2})
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`vaultage_pending_changes{vault="family"} 1`,
+		`vaultage_retry_attempt{vault="work"} 2`,
+		`vaultage_backups_total{result="success",vault="family"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+}
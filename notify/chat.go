@@ -0,0 +1,249 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Severity orders events by how urgent they are.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseSeverity parses info, warning or error.
+func ParseSeverity(s string) (Severity, error) {
+	switch s {
+	case "info":
+		return SeverityInfo, nil
+	case "warning":
+		return SeverityWarning, nil
+	case "error":
+		return SeverityError, nil
+	}
+	return 0, fmt.Errorf("invalid severity %q: expected info, warning or error", s)
+}
+
+// Severity returns how urgent e is. Failures are errors, successes with
+// spooled uploads or failed pruning are warnings, the rest is info.
+func (e Event) Severity() Severity {
+	if e.Type == BackupFailed {
+		return SeverityError
+	}
+	if e.Type == BackupSucceeded {
+		for _, d := range e.Destinations {
+			if d.Spooled || d.PruneError != "" {
+				return SeverityWarning
+			}
+		}
+	}
+	return SeverityInfo
+}
+
+// Title returns a one-line summary of e.
+func (e Event) Title() string {
+	var title string
+	switch {
+	case e.Type == BackupFailed:
+		title = "Backup failed"
+	case e.Type == RetentionPruned:
+		title = "Old backups pruned"
+	case e.Severity() == SeverityWarning:
+		title = "Backup succeeded with warnings"
+	default:
+		title = "Backup succeeded"
+	}
+	if e.Instance != "" {
+		title += " for " + e.Instance
+	}
+	return title + " on " + hostname()
+}
+
+// DefaultTemplate renders chat messages unless --notify-template is set.
+// It uses Markdown, which is converted or sent as is depending on the service.
+const DefaultTemplate = `{{ if .Error }}**Error:** {{ .Error }}
+{{ end }}{{ if .Archive }}**Archive:** {{ .Archive }} ({{ size .Size }}, {{ printf "%.1f" .Duration }}s)
+{{ end }}{{ range .Destinations }}- {{ .Destination }}: {{ if .Spooled }}queued for retry{{ else if .OK }}ok{{ else }}failed: {{ .Error }}{{ end }}{{ if .Pruned }}, pruned {{ len .Pruned }} archive(s){{ end }}{{ if .PruneError }}, pruning failed: {{ .PruneError }}{{ end }}
+{{ end }}`
+
+// Message is a rendered event, ready for a chat service.
+type Message struct {
+	Title    string
+	Body     string
+	Severity Severity
+}
+
+// Number of delivery attempts per chat message.
+const chatAttempts = 3
+
+// sender delivers messages to one chat service.
+type sender interface {
+	send(ctx context.Context, client *http.Client, m Message) error
+	String() string
+}
+
+// Chat sends events at or above a minimum severity to a chat service.
+type Chat struct {
+	sender
+	client      *http.Client
+	template    *template.Template
+	minSeverity Severity
+}
+
+// Default minimum severity of chat notifiers, so successful backups do
+// not flood the channel.
+const defaultMinSeverity = SeverityWarning
+
+// NewChat returns a notifier for a chat service URL:
+//
+//	ntfy://[user:password@]host/topic
+//	gotify://host/token
+//	discord://discord.com/api/webhooks/id/token
+//	slack://hooks.slack.com/services/T0/B0/secret
+//	matrix://:access-token@homeserver/!room:server
+//
+// All URLs accept min-severity (info, warning or error, default warning)
+// and scheme=http to talk plain HTTP to local servers. The message body
+// is rendered with tmpl, see DefaultTemplate.
+func NewChat(rawURL string, tmpl *template.Template) (*Chat, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification URL %q", redactURL(rawURL))
+	}
+
+	q := u.Query()
+	minSeverity := defaultMinSeverity
+	if v := q.Get("min-severity"); v != "" {
+		if minSeverity, err = ParseSeverity(v); err != nil {
+			return nil, err
+		}
+	}
+	scheme := "https"
+	if v := q.Get("scheme"); v != "" {
+		if v != "http" && v != "https" {
+			return nil, fmt.Errorf("invalid scheme %q: expected http or https", v)
+		}
+		scheme = v
+	}
+	q.Del("min-severity")
+	q.Del("scheme")
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s notification URL requires a host", u.Scheme)
+	}
+
+	var s sender
+	switch u.Scheme {
+	case "ntfy":
+		s, err = newNtfy(u, scheme, q)
+	case "gotify":
+		s, err = newGotify(u, scheme, q)
+	case "discord":
+		s, err = newDiscord(u, scheme, q)
+	case "slack":
+		s, err = newSlack(u, scheme, q)
+	case "matrix":
+		s, err = newMatrix(u, scheme, q)
+	default:
+		return nil, fmt.Errorf("unsupported notification scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tmpl == nil {
+		tmpl = template.Must(ParseTemplate("default", DefaultTemplate))
+	}
+
+	return &Chat{
+		sender:      s,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		template:    tmpl,
+		minSeverity: minSeverity,
+	}, nil
+}
+
+func (c *Chat) Notify(ctx context.Context, e Event) error {
+	if e.Severity() < c.minSeverity {
+		return nil
+	}
+
+	var body bytes.Buffer
+	if err := c.template.Execute(&body, e); err != nil {
+		return fmt.Errorf("rendering notification template: %w", err)
+	}
+	m := Message{Title: e.Title(), Body: strings.TrimSpace(body.String()), Severity: e.Severity()}
+
+	return withRetries(ctx, chatAttempts, func() error {
+		return c.send(ctx, c.client, m)
+	})
+}
+
+// Rejects query parameters not in allowed.
+func checkQuery(q url.Values, allowed ...string) error {
+	for key := range q {
+		if !slices.Contains(allowed, key) {
+			return fmt.Errorf("unsupported query parameter %q", key)
+		}
+	}
+	return nil
+}
+
+// Sends a JSON request and checks for a 2xx answer. The error does not
+// include the URL, which usually contains a token.
+func sendJSON(ctx context.Context, client *http.Client, method, target string, header http.Header, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "vaultage")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("sending to %s: %w", redactURL(target), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return statusError(resp.StatusCode, fmt.Errorf("%s answered %s: %s", redactURL(target), resp.Status, bytes.TrimSpace(msg)))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return nil
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type chatRequest struct {
+	method, path, auth string
+	body               map[string]any
+}
+
+// Runs a stand-in for a chat service that records requests.
+func chatStub(t *testing.T) (host string, requests <-chan chatRequest) {
+	t.Helper()
+	ch := make(chan chatRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		req := chatRequest{method: r.Method, path: r.URL.Path, auth: r.Header.Get("Authorization")}
+		if key := r.Header.Get("X-Gotify-Key"); key != "" {
+			req.auth = "gotify " + key
+		}
+		if err := json.Unmarshal(data, &req.body); err != nil {
+			t.Errorf("invalid JSON body %s: %v", data, err)
+		}
+		ch <- req
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), ch
+}
+
+func TestChat_Services(t *testing.T) {
+	host, requests := chatStub(t)
+	failure := Event{
+		Type:         BackupFailed,
+		Error:        "disk full",
+		Destinations: []DestinationResult{{Destination: "/backups", Error: "disk full"}},
+	}
+
+	tests := []struct {
+		url    string
+		method string
+		path   string
+		auth   string
+		check  func(body map[string]any) bool
+	}{
+		{
+			url:    "ntfy://tk_secret@" + host + "/backups?scheme=http",
+			method: "POST", path: "/", auth: "Bearer tk_secret",
+			check: func(b map[string]any) bool {
+				return b["topic"] == "backups" && b["priority"] == 5.0 && strings.Contains(b["message"].(string), "**Error:** disk full")
+			},
+		},
+		{
+			url:    "gotify://" + host + "/gotify/AppToken?scheme=http",
+			method: "POST", path: "/gotify/message", auth: "gotify AppToken",
+			check: func(b map[string]any) bool {
+				return b["priority"] == 8.0 && strings.HasPrefix(b["title"].(string), "Backup failed")
+			},
+		},
+		{
+			url:    "discord://" + host + "/api/webhooks/1/token?scheme=http",
+			method: "POST", path: "/api/webhooks/1/token",
+			check: func(b map[string]any) bool {
+				embed := b["embeds"].([]any)[0].(map[string]any)
+				return embed["color"] == float64(0xe74c3c) && strings.Contains(embed["description"].(string), "disk full")
+			},
+		},
+		{
+			url:    "slack://" + host + "/services/T0/B0/secret?scheme=http",
+			method: "POST", path: "/services/T0/B0/secret",
+			check: func(b map[string]any) bool {
+				section := b["blocks"].([]any)[1].(map[string]any)["text"].(map[string]any)
+				return strings.Contains(section["text"].(string), "*Error:* disk full")
+			},
+		},
+		{
+			url:    "matrix://:syt_token@" + host + "/!room:example.org?scheme=http",
+			method: "PUT", path: "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/", auth: "Bearer syt_token",
+			check: func(b map[string]any) bool {
+				return b["msgtype"] == "m.text" && strings.Contains(b["formatted_body"].(string), "<strong>Error:</strong> disk full")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.SplitN(tt.url, ":", 2)[0], func(t *testing.T) {
+			chat, err := NewChat(tt.url, nil)
+			if err != nil {
+				t.Fatalf("NewChat: %v", err)
+			}
+			if strings.Contains(chat.String(), "secret") || strings.Contains(chat.String(), "token") {
+				t.Fatalf("String leaks secrets: %s", chat)
+			}
+			if err := chat.Notify(context.Background(), failure); err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+
+			req := <-requests
+			if req.method != tt.method || !strings.HasPrefix(req.path, tt.path) || req.auth != tt.auth {
+				t.Fatalf("unexpected request %s %s (auth %q)", req.method, req.path, req.auth)
+			}
+			if !tt.check(req.body) {
+				t.Fatalf("unexpected payload: %v", req.body)
+			}
+		})
+	}
+}
+
+func TestChat_MinSeverity(t *testing.T) {
+	host, requests := chatStub(t)
+	success := Event{Type: BackupSucceeded, Archive: "vaultage-1.tar.age"}
+	spooled := Event{Type: BackupSucceeded, Destinations: []DestinationResult{{Destination: "s3://bucket", Spooled: true}}}
+
+	chat, err := NewChat("ntfy://"+host+"/backups?scheme=http", nil)
+	if err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	for _, e := range []Event{success, spooled} {
+		if err := chat.Notify(context.Background(), e); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+	if req := <-requests; req.body["priority"] != 4.0 {
+		t.Fatalf("expected only the warning, got %v", req.body)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("expected success to be filtered by the default min-severity")
+	}
+
+	chat, err = NewChat("ntfy://"+host+"/backups?scheme=http&min-severity=info", nil)
+	if err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	if err := chat.Notify(context.Background(), success); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if req := <-requests; req.body["priority"] != 3.0 {
+		t.Fatalf("unexpected info message: %v", req.body)
+	}
+}
+
+func TestNewChat_Invalid(t *testing.T) {
+	for _, rawURL := range []string{
+		"telegram://bot/chat",
+		"ntfy://ntfy.sh/",
+		"gotify://gotify.example.com",
+		"matrix://matrix.org/!room:matrix.org",
+		"ntfy://ntfy.sh/backups?min-severity=debug",
+		"slack://hooks.slack.com/services/x?channel=general",
+	} {
+		if _, err := NewChat(rawURL, nil); err == nil {
+			t.Errorf("NewChat(%q): expected error", rawURL)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Sends messages to a Discord channel webhook as embeds.
+type discord struct {
+	webhook *url.URL
+}
+
+func newDiscord(u *url.URL, scheme string, q url.Values) (*discord, error) {
+	if err := checkQuery(q, "thread_id"); err != nil {
+		return nil, err
+	}
+	webhook := *u
+	webhook.Scheme = scheme
+	webhook.RawQuery = q.Encode()
+	return &discord{webhook: &webhook}, nil
+}
+
+func (d *discord) String() string {
+	return "discord " + d.webhook.Host
+}
+
+// Embed colors: green, orange and red.
+var discordColor = map[Severity]int{SeverityInfo: 0x2ecc71, SeverityWarning: 0xf39c12, SeverityError: 0xe74c3c}
+
+func (d *discord) send(ctx context.Context, client *http.Client, m Message) error {
+	return sendJSON(ctx, client, http.MethodPost, d.webhook.String(), nil, map[string]any{
+		"username": "vaultage",
+		"embeds": []map[string]any{{
+			"title":       m.Title,
+			"description": m.Body,
+			"color":       discordColor[m.Severity],
+		}},
+	})
+}
@@ -0,0 +1,333 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mijolabs/vaultage/logging"
+)
+
+// SMTP connection security, named like Vaultwarden's SMTP_SECURITY.
+const (
+	SMTPStartTLS = "starttls"
+	SMTPForceTLS = "force_tls"
+	SMTPOff      = "off"
+)
+
+// Number of delivery attempts per email.
+const emailAttempts = 3
+
+// SMTPConfig describes how to send email.
+type SMTPConfig struct {
+	Host string
+	// Port defaults to 587 for starttls, 465 for force_tls and 25 for off.
+	Port int
+	// Security is one of SMTPStartTLS (the default), SMTPForceTLS or SMTPOff.
+	Security string
+	Username string
+	Password string
+	From     string
+	FromName string
+	Timeout  time.Duration
+}
+
+// Returns the port to connect to.
+func (c SMTPConfig) port() int {
+	switch {
+	case c.Port != 0:
+		return c.Port
+	case c.Security == SMTPForceTLS:
+		return 465
+	case c.Security == SMTPOff:
+		return 25
+	default:
+		return 587
+	}
+}
+
+// Validate reports missing or invalid settings.
+func (c SMTPConfig) Validate() error {
+	if c.Host == "" {
+		return fmt.Errorf("SMTP host is not set")
+	}
+	if c.From == "" {
+		return fmt.Errorf("SMTP sender address is not set")
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("invalid SMTP sender address %q: %w", c.From, err)
+	}
+	switch c.Security {
+	case "", SMTPStartTLS, SMTPForceTLS, SMTPOff:
+	default:
+		return fmt.Errorf("invalid SMTP security %q: expected starttls, force_tls or off", c.Security)
+	}
+	return nil
+}
+
+// Email sends an email for every failed backup. With a digest interval
+// set, successful backups are collected and summarized in one email at
+// most once per interval. A digest held back is sent when the interval
+// is over, or by Flush.
+type Email struct {
+	smtp   SMTPConfig
+	to     []string
+	digest time.Duration
+
+	mu         sync.Mutex
+	successes  []Event
+	lastDigest time.Time
+	timer      *time.Timer
+
+	// Held while a held back digest is sent, so Flush waits for the timer
+	flushMu sync.Mutex
+}
+
+// NewEmail returns an email notifier sending to the given recipients.
+func NewEmail(cfg SMTPConfig, to []string, digest time.Duration) (*Email, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Security == "" {
+		cfg.Security = SMTPStartTLS
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 15 * time.Second
+	}
+	for _, addr := range to {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid email recipient %q: %w", addr, err)
+		}
+	}
+	return &Email{smtp: cfg, to: to, digest: digest}, nil
+}
+
+func (m *Email) String() string {
+	return fmt.Sprintf("email via %s:%d", m.smtp.Host, m.smtp.port())
+}
+
+func (m *Email) Notify(ctx context.Context, e Event) error {
+	switch e.Type {
+	case BackupFailed:
+		return m.send(ctx, e.Title(), failureBody(e))
+	case BackupSucceeded:
+		if events := m.addSuccess(e); len(events) > 0 {
+			return m.sendDigest(ctx, events)
+		}
+	}
+	return nil
+}
+
+// Flush sends the successes held back for the next digest right away.
+func (m *Email) Flush(ctx context.Context) error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	events := m.takeSuccesses()
+	m.mu.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	return m.sendDigest(ctx, events)
+}
+
+// Records a success and returns the successes to send if a digest is due.
+// Otherwise a timer sends them once the interval is over.
+func (m *Email) addSuccess(e Event) []Event {
+	if m.digest <= 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.successes = append(m.successes, e)
+	if wait := m.digest - time.Since(m.lastDigest); wait > 0 {
+		if m.timer == nil {
+			m.timer = time.AfterFunc(wait, m.flushDue)
+		}
+		return nil
+	}
+	return m.takeSuccesses()
+}
+
+// Returns and clears the collected successes. The caller must hold m.mu.
+func (m *Email) takeSuccesses() []Event {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	events := m.successes
+	m.successes = nil
+	if len(events) > 0 {
+		m.lastDigest = time.Now()
+	}
+	return events
+}
+
+// Sends the digest held back once its interval is over.
+func (m *Email) flushDue() {
+	if err := m.Flush(context.Background()); err != nil {
+		slog.Error("notification failed", "notifier", m.String(), "event", BackupSucceeded, logging.Err(err))
+	}
+}
+
+func (m *Email) sendDigest(ctx context.Context, events []Event) error {
+	subject := "Backup digest"
+	if instance := events[len(events)-1].Instance; instance != "" {
+		subject += " for " + instance
+	}
+	return m.send(ctx, fmt.Sprintf("%s: %d successful backup(s) on %s", subject, len(events), hostname()), digestBody(events))
+}
+
+func (m *Email) send(ctx context.Context, subject, body string) error {
+	msg, err := m.message(subject, body)
+	if err != nil {
+		return err
+	}
+	return withRetries(ctx, emailAttempts, func() error {
+		return m.deliver(ctx, msg)
+	})
+}
+
+// Builds the RFC 5322 message.
+func (m *Email) message(subject, body string) ([]byte, error) {
+	from := mail.Address{Name: m.smtp.FromName, Address: m.smtp.From}
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := m.smtp.From[strings.LastIndex(m.smtp.From, "@")+1:]
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[vaultage] "+subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Connects to the SMTP server and sends msg.
+func (m *Email) deliver(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(m.smtp.Host, strconv.Itoa(m.smtp.port()))
+	tlsConfig := &tls.Config{ServerName: m.smtp.Host}
+
+	dialer := &net.Dialer{Timeout: m.smtp.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(m.smtp.Timeout))
+	if m.smtp.Security == SMTPForceTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, m.smtp.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer c.Close()
+
+	if m.smtp.Security == SMTPStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starting TLS: %w", err)
+		}
+	}
+	if m.smtp.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.smtp.Username, m.smtp.Password, m.smtp.Host)); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	if err := c.Mail(m.smtp.From); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	for _, to := range m.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("sending email to %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return c.Quit()
+}
+
+func failureBody(e Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "A vaultage backup failed at %s.\n\n", e.Time.Format(time.RFC1123))
+	fmt.Fprintf(&b, "Error: %s\n", e.Error)
+	if e.Archive != "" {
+		fmt.Fprintf(&b, "Archive: %s (%d bytes)\n", e.Archive, e.Size)
+	}
+	writeDestinations(&b, e.Destinations)
+	return b.String()
+}
+
+func digestBody(events []Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d vaultage backup(s) succeeded since the last digest:\n\n", len(events))
+	for _, e := range events {
+		fmt.Fprintf(&b, "%s  %s (%d bytes, %.1fs)\n", e.Time.Format(time.DateTime), e.Archive, e.Size, e.Duration)
+	}
+	writeDestinations(&b, events[len(events)-1].Destinations)
+	return b.String()
+}
+
+func writeDestinations(b *strings.Builder, destinations []DestinationResult) {
+	if len(destinations) == 0 {
+		return
+	}
+	b.WriteString("\nDestinations:\n")
+	for _, d := range destinations {
+		switch {
+		case d.Spooled:
+			fmt.Fprintf(b, "  %s: queued for retry: %s\n", d.Destination, d.Error)
+		case !d.OK:
+			fmt.Fprintf(b, "  %s: failed: %s\n", d.Destination, d.Error)
+		default:
+			fmt.Fprintf(b, "  %s: ok\n", d.Destination)
+		}
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown host"
+	}
+	return name
+}
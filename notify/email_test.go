@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs a minimal SMTP server that accepts all mail and returns the
+// received messages.
+func smtpStub(t *testing.T) (host string, port int, messages <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, ch)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, ch
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 stub ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch cmd {
+		case "EHLO", "HELO", "MAIL", "RCPT":
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			messages <- msg.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	host, port, messages := smtpStub(t)
+	cfg := SMTPConfig{Host: host, Port: port, Security: SMTPOff, From: "vaultage@example.com", FromName: "Vaultage"}
+
+	email, err := NewEmail(cfg, []string{"admin@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatalf("NewEmail: %v", err)
+	}
+	ctx := context.Background()
+
+	failure := Event{
+		Type:         BackupFailed,
+		Time:         time.Now(),
+		Error:        "1 of 1 destinations failed",
+		Destinations: []DestinationResult{{Destination: "s3://bucket", Error: "connection refused"}},
+	}
+	if err := email.Notify(ctx, failure); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	msg := <-messages
+	for _, want := range []string{"Subject: [vaultage] Backup failed on", "To: admin@example.com", "s3://bucket: failed: connection refused"} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("expected %q in message:\n%s", want, msg)
+		}
+	}
+
+	// The first success starts a digest, the next ones wait for the interval
+	success := Event{Type: BackupSucceeded, Time: time.Now(), Archive: "vaultage-1.tar.age"}
+	for range 2 {
+		if err := email.Notify(ctx, success); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+	if msg := <-messages; !strings.Contains(msg, "Backup digest: 1 successful backup(s)") {
+		t.Fatalf("expected digest, got:\n%s", msg)
+	}
+	select {
+	case msg := <-messages:
+		t.Fatalf("expected no second digest within the interval, got:\n%s", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Waiting for the dispatcher at shutdown sends the held back success
+	NewDispatcher(email).Wait()
+	if msg := <-messages; !strings.Contains(msg, "Backup digest: 1 successful backup(s)") {
+		t.Fatalf("expected digest on shutdown, got:\n%s", msg)
+	}
+}
+
+func TestEmail_DigestTimer(t *testing.T) {
+	host, port, messages := smtpStub(t)
+	cfg := SMTPConfig{Host: host, Port: port, Security: SMTPOff, From: "vaultage@example.com"}
+
+	email, err := NewEmail(cfg, []string{"admin@example.com"}, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewEmail: %v", err)
+	}
+	ctx := context.Background()
+
+	success := Event{Type: BackupSucceeded, Time: time.Now(), Archive: "vaultage-1.tar.age"}
+	for range 3 {
+		if err := email.Notify(ctx, success); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+	<-messages
+
+	// The held back successes are sent once the interval is over, without
+	// waiting for another backup
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "Backup digest: 2 successful backup(s)") {
+			t.Fatalf("expected digest of 2 backups, got:\n%s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected digest after the interval")
+	}
+}
+
+func TestNewEmail_Invalid(t *testing.T) {
+	if _, err := NewEmail(SMTPConfig{From: "a@example.com"}, []string{"b@example.com"}, 0); err == nil {
+		t.Fatal("expected error without host")
+	}
+	if _, err := NewEmail(SMTPConfig{Host: "mail", From: "a@example.com", Security: "ssl"}, nil, 0); err == nil {
+		t.Fatal("expected error for invalid security")
+	}
+}
+
+func TestVaultwardenSMTP(t *testing.T) {
+	t.Setenv("SMTP_HOST", "env.example.com")
+	t.Setenv("SMTP_PORT", "2525")
+	t.Setenv("SMTP_FROM", "env@example.com")
+
+	dir := t.TempDir()
+	cfg, err := VaultwardenSMTP(dir)
+	if err != nil {
+		t.Fatalf("VaultwardenSMTP: %v", err)
+	}
+	if cfg.Host != "env.example.com" || cfg.Port != 2525 {
+		t.Fatalf("unexpected config from env: %+v", cfg)
+	}
+
+	config := `{"smtp_host": "json.example.com", "smtp_security": "force_tls", "smtp_timeout": 30, "domain": "https://vault.example.com"}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = VaultwardenSMTP(dir)
+	if err != nil {
+		t.Fatalf("VaultwardenSMTP: %v", err)
+	}
+	if cfg.Host != "json.example.com" || cfg.Security != SMTPForceTLS || cfg.Timeout != 30*time.Second {
+		t.Fatalf("config.json did not take precedence: %+v", cfg)
+	}
+	if cfg.From != "env@example.com" || cfg.Port != 2525 {
+		t.Fatalf("env settings missing from merged config: %+v", cfg)
+	}
+}
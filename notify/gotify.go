@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sends messages to a Gotify server with an application token.
+type gotify struct {
+	endpoint *url.URL
+	token    string
+}
+
+func newGotify(u *url.URL, scheme string, q url.Values) (*gotify, error) {
+	if err := checkQuery(q); err != nil {
+		return nil, err
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	token := path[i+1:]
+	if token == "" {
+		return nil, fmt.Errorf("gotify URL requires an application token, e.g. gotify://gotify.example.com/token")
+	}
+
+	base := strings.TrimSuffix("/"+path[:max(i, 0)], "/")
+	return &gotify{
+		endpoint: &url.URL{Scheme: scheme, Host: u.Host, Path: base + "/message"},
+		token:    token,
+	}, nil
+}
+
+func (g *gotify) String() string {
+	return "gotify " + g.endpoint.Host
+}
+
+// Gotify priorities, where clients usually alert from 4 and up.
+var gotifyPriority = map[Severity]int{SeverityInfo: 2, SeverityWarning: 5, SeverityError: 8}
+
+func (g *gotify) send(ctx context.Context, client *http.Client, m Message) error {
+	return sendJSON(ctx, client, http.MethodPost, g.endpoint.String(), http.Header{"X-Gotify-Key": {g.token}}, map[string]any{
+		"title":    m.Title,
+		"message":  m.Body,
+		"priority": gotifyPriority[m.Severity],
+		"extras": map[string]any{
+			"client::display": map[string]string{"contentType": "text/markdown"},
+		},
+	})
+}
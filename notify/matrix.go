@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sends messages to a Matrix room with the client-server API.
+type matrix struct {
+	homeserver *url.URL
+	room       string
+	token      string
+}
+
+func newMatrix(u *url.URL, scheme string, q url.Values) (*matrix, error) {
+	if err := checkQuery(q); err != nil {
+		return nil, err
+	}
+	room := strings.Trim(u.Path, "/")
+	if !strings.HasPrefix(room, "!") {
+		return nil, fmt.Errorf("matrix URL requires a room ID, e.g. matrix://:token@matrix.org/!room:matrix.org")
+	}
+	token, _ := u.User.Password()
+	if token == "" {
+		return nil, fmt.Errorf("matrix URL requires an access token as password, e.g. matrix://:token@matrix.org/!room:matrix.org")
+	}
+	return &matrix{
+		homeserver: &url.URL{Scheme: scheme, Host: u.Host},
+		room:       room,
+		token:      token,
+	}, nil
+}
+
+func (m *matrix) String() string {
+	return "matrix " + m.homeserver.Host
+}
+
+func (m *matrix) send(ctx context.Context, client *http.Client, msg Message) error {
+	txn := make([]byte, 16)
+	rand.Read(txn)
+	target := m.homeserver.JoinPath("_matrix/client/v3/rooms", m.room, "send/m.room.message", hex.EncodeToString(txn))
+
+	// Errors are sent as regular messages so clients notify about them
+	msgtype := "m.notice"
+	if msg.Severity == SeverityError {
+		msgtype = "m.text"
+	}
+
+	return sendJSON(ctx, client, http.MethodPut, target.String(), http.Header{"Authorization": {"Bearer " + m.token}}, map[string]any{
+		"msgtype":        msgtype,
+		"body":           msg.Title + "\n\n" + msg.Body,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": "<strong>" + html.EscapeString(msg.Title) + "</strong><br>" + markdownToHTML(msg.Body),
+	})
+}
+
+// Converts the Markdown subset used by DefaultTemplate (bold and list
+// items) to HTML. Everything else is escaped.
+func markdownToHTML(s string) string {
+	var b strings.Builder
+	inList := false
+	for line := range strings.Lines(s) {
+		line = strings.TrimRight(line, "\n")
+		item, isItem := strings.CutPrefix(line, "- ")
+		if isItem != inList {
+			if isItem {
+				b.WriteString("<ul>")
+			} else {
+				b.WriteString("</ul>")
+			}
+			inList = isItem
+		}
+		text := markdownBold.ReplaceAllString(html.EscapeString(item), "<strong>$1</strong>")
+		if isItem {
+			b.WriteString("<li>" + text + "</li>")
+		} else {
+			b.WriteString(text + "<br>")
+		}
+	}
+	if inList {
+		b.WriteString("</ul>")
+	}
+	return b.String()
+}
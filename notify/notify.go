@@ -0,0 +1,222 @@
+// Package notify sends notifications about backup events to external services.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/logging"
+)
+
+// EventType identifies what happened.
+type EventType string
+
+const (
+	BackupSucceeded EventType = "backup.success"
+	BackupFailed    EventType = "backup.failure"
+	// RetentionPruned is sent in addition to BackupSucceeded when
+	// retention policies deleted archives.
+	RetentionPruned EventType = "retention.pruned"
+)
+
+// Event describes a backup event. It is the JSON payload of webhooks and
+// the data passed to templates.
+type Event struct {
+	Type         EventType           `json:"event"`
+	Time         time.Time           `json:"time"`
+	Instance     string              `json:"instance,omitempty"`
+	Archive      string              `json:"archive,omitempty"`
+	Size         int64               `json:"size,omitempty"`
+	Duration     float64             `json:"duration_seconds"`
+	Destinations []DestinationResult `json:"destinations,omitempty"`
+	Error        string              `json:"error,omitempty"`
+}
+
+// DestinationResult reports the outcome at one destination.
+type DestinationResult struct {
+	Destination string   `json:"destination"`
+	OK          bool     `json:"ok"`
+	Error       string   `json:"error,omitempty"`
+	Spooled     bool     `json:"spooled,omitempty"`
+	Pruned      []string `json:"pruned,omitempty"`
+	PruneError  string   `json:"prune_error,omitempty"`
+}
+
+// Events returns the events describing a backup run.
+func Events(result backup.Result, err error) []Event {
+	base := Event{
+		Time:     time.Now(),
+		Instance: result.Instance,
+		Archive:  result.Filename,
+		Size:     result.Size,
+		Duration: result.Duration.Seconds(),
+	}
+
+	pruned := false
+	for _, d := range result.Destinations {
+		dr := DestinationResult{
+			Destination: d.Destination,
+			OK:          d.Err == nil,
+			Spooled:     d.Spooled,
+			Pruned:      d.Pruned,
+		}
+		if d.Err != nil {
+			dr.Error = d.Err.Error()
+		}
+		if d.PruneErr != nil {
+			dr.PruneError = d.PruneErr.Error()
+		}
+		pruned = pruned || len(d.Pruned) > 0
+		base.Destinations = append(base.Destinations, dr)
+	}
+
+	if err != nil {
+		failed := base
+		failed.Type = BackupFailed
+		failed.Error = err.Error()
+		return []Event{failed}
+	}
+
+	succeeded := base
+	succeeded.Type = BackupSucceeded
+	events := []Event{succeeded}
+	if pruned {
+		prunedEvent := base
+		prunedEvent.Type = RetentionPruned
+		events = append(events, prunedEvent)
+	}
+	return events
+}
+
+// ParseTemplate parses a text/template for rendering events. Besides the
+// Event fields and methods, templates can use the json function to encode
+// a value, e.g. {{ json .Error }} for a quoted string, and the size
+// function to format a byte count.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"size": logging.FormatSize,
+	}).Parse(text)
+}
+
+// Notifier delivers events to one external service.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+	// String describes the notifier without secrets, for logs.
+	String() string
+}
+
+// Implemented by notifiers that hold events back to batch them.
+type flusher interface {
+	// Flush delivers the events held back.
+	Flush(ctx context.Context) error
+}
+
+// Dispatcher sends events to all notifiers in the background, so slow
+// or unreachable services do not hold up backups.
+type Dispatcher struct {
+	notifiers []Notifier
+	wg        sync.WaitGroup
+}
+
+// NewDispatcher returns a Dispatcher for notifiers.
+func NewDispatcher(notifiers ...Notifier) *Dispatcher {
+	return &Dispatcher{notifiers: notifiers}
+}
+
+// Len returns the number of notifiers.
+func (d *Dispatcher) Len() int {
+	return len(d.notifiers)
+}
+
+// Dispatch starts delivering events to every notifier. Failures are logged.
+func (d *Dispatcher) Dispatch(ctx context.Context, events ...Event) {
+	for _, n := range d.notifiers {
+		d.wg.Go(func() {
+			for _, e := range events {
+				if err := n.Notify(ctx, e); err != nil && !errors.Is(err, context.Canceled) {
+					slog.ErrorContext(ctx, "notification failed", "notifier", n.String(), "event", e.Type, logging.Err(err))
+				}
+			}
+		})
+	}
+}
+
+// Wait blocks until all dispatched events were delivered or given up on,
+// then flushes notifiers that hold events back, such as email digests.
+// Call it before exiting so no held back events are lost.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+	for _, n := range d.notifiers {
+		f, ok := n.(flusher)
+		if !ok {
+			continue
+		}
+		if err := f.Flush(context.Background()); err != nil {
+			slog.Error("notification failed", "notifier", n.String(), logging.Err(err))
+		}
+	}
+}
+
+// Delay before the first retry of a failed delivery, doubled for every
+// further attempt.
+var retryBaseDelay = 1 * time.Second
+
+// Returns the delay before the given retry attempt (1-based).
+func retryDelay(attempt int) time.Duration {
+	return retryBaseDelay << (attempt - 1)
+}
+
+// Calls send up to attempts times, waiting with exponential backoff
+// between failed attempts. Permanent errors are returned right away.
+func withRetries(ctx context.Context, attempts int, send func() error) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = send(); err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if attempt == attempts || errors.As(err, &permanent) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(retryDelay(attempt)):
+		}
+	}
+	return err
+}
+
+// permanentError marks a failed delivery that a retry would not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Returns err for a response with an unsuccessful status code, marked as
+// permanent for client errors such as 401 or 404. Timeouts and rate
+// limits are retried.
+func statusError(code int, err error) error {
+	if code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests {
+		return &permanentError{err: err}
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mijolabs/vaultage/backup"
+)
+
+func TestEvents(t *testing.T) {
+	result := backup.Result{
+		Filename: "vaultage-20260101_000000.tar.age",
+		Size:     1024,
+		Duration: 2 * time.Second,
+		Destinations: []backup.DestinationResult{
+			{Destination: "/backups", Pruned: []string{"vaultage-20250101_000000.tar.age"}},
+		},
+	}
+
+	events := Events(result, nil)
+	if len(events) != 2 || events[0].Type != BackupSucceeded || events[1].Type != RetentionPruned {
+		t.Fatalf("expected success and pruned events, got %+v", events)
+	}
+	if events[0].Archive != result.Filename || events[0].Duration != 2 || !events[0].Destinations[0].OK {
+		t.Fatalf("unexpected success event: %+v", events[0])
+	}
+
+	result.Destinations = []backup.DestinationResult{
+		{Destination: "s3://bucket", Err: errors.New("connection refused")},
+	}
+	events = Events(result, errors.New("1 of 1 destinations failed"))
+	if len(events) != 1 || events[0].Type != BackupFailed || events[0].Error == "" {
+		t.Fatalf("expected a failure event, got %+v", events)
+	}
+	if d := events[0].Destinations[0]; d.OK || d.Error != "connection refused" {
+		t.Fatalf("unexpected destination result: %+v", d)
+	}
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sends messages to an ntfy topic with the JSON publishing API.
+type ntfy struct {
+	server *url.URL
+	topic  string
+	// Basic auth, or an access token when password is empty
+	user, password string
+}
+
+func newNtfy(u *url.URL, scheme string, q url.Values) (*ntfy, error) {
+	if err := checkQuery(q); err != nil {
+		return nil, err
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	topic := path[i+1:]
+	if topic == "" {
+		return nil, fmt.Errorf("ntfy URL requires a topic, e.g. ntfy://ntfy.sh/backups")
+	}
+
+	n := &ntfy{
+		server: &url.URL{Scheme: scheme, Host: u.Host, Path: "/" + path[:max(i, 0)]},
+		topic:  topic,
+	}
+	if u.User != nil {
+		n.user = u.User.Username()
+		n.password, _ = u.User.Password()
+	}
+	return n, nil
+}
+
+func (n *ntfy) String() string {
+	return "ntfy " + n.server.Host
+}
+
+// ntfy priorities from 1 (min) to 5 (max).
+var ntfyPriority = map[Severity]int{SeverityInfo: 3, SeverityWarning: 4, SeverityError: 5}
+
+var ntfyTags = map[Severity][]string{
+	SeverityInfo:    {"white_check_mark"},
+	SeverityWarning: {"warning"},
+	SeverityError:   {"rotating_light"},
+}
+
+func (n *ntfy) send(ctx context.Context, client *http.Client, m Message) error {
+	header := http.Header{}
+	switch {
+	case n.password != "":
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(n.user+":"+n.password)))
+	case n.user != "":
+		header.Set("Authorization", "Bearer "+n.user)
+	}
+
+	return sendJSON(ctx, client, http.MethodPost, n.server.String(), header, map[string]any{
+		"topic":    n.topic,
+		"title":    m.Title,
+		"message":  m.Body,
+		"priority": ntfyPriority[m.Severity],
+		"tags":     ntfyTags[m.Severity],
+		"markdown": true,
+	})
+}
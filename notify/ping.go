@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Number of delivery attempts for result pings.
+const pingAttempts = 3
+
+// Ping signals a dead man's switch such as healthchecks.io or Uptime
+// Kuma: <url>/start when a backup begins, <url> on success and
+// <url>/fail on failure, with the error in the body.
+type Ping struct {
+	client *http.Client
+	url    *url.URL
+}
+
+// NewPing returns a Ping for an http or https URL.
+func NewPing(rawURL string) (*Ping, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid ping URL %q: expected an http or https URL", redactURL(rawURL))
+	}
+	return &Ping{client: &http.Client{Timeout: 10 * time.Second}, url: u}, nil
+}
+
+// String returns the ping host, the URL path usually is the check's secret.
+func (p *Ping) String() string {
+	return "ping " + redactURL(p.url.String())
+}
+
+// Start signals that a backup began. It is not retried, so a slow
+// monitor delays the backup by at most the request timeout.
+func (p *Ping) Start(ctx context.Context) error {
+	return p.send(ctx, "/start", "")
+}
+
+// Notify signals the outcome of a backup. Other events are ignored.
+func (p *Ping) Notify(ctx context.Context, e Event) error {
+	switch e.Type {
+	case BackupSucceeded:
+		return withRetries(ctx, pingAttempts, func() error {
+			return p.send(ctx, "", fmt.Sprintf("%s (%d bytes) in %.1fs", e.Archive, e.Size, e.Duration))
+		})
+	case BackupFailed:
+		return withRetries(ctx, pingAttempts, func() error {
+			return p.send(ctx, "/fail", e.Error)
+		})
+	}
+	return nil
+}
+
+// Keepalive repeats the last outcome, so the monitor notices when vaultage
+// stops running even while there is nothing to back up. lastError is the
+// error of the last backup, empty if it succeeded or none ran yet.
+func (p *Ping) Keepalive(ctx context.Context, lastError string) error {
+	if lastError != "" {
+		return p.send(ctx, "/fail", lastError)
+	}
+	return p.send(ctx, "", "")
+}
+
+// POSTs body to the ping URL with suffix appended to its path.
+func (p *Ping) send(ctx context.Context, suffix, body string) error {
+	u := *p.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + suffix
+	u.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "vaultage")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("pinging %s: %w", redactURL(p.url.String()), err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp.StatusCode, fmt.Errorf("ping answered %s", resp.Status))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	type ping struct{ path, query, body string }
+	pings := make(chan ping, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pings <- ping{r.URL.Path, r.URL.RawQuery, string(body)}
+	}))
+	defer srv.Close()
+
+	p, err := NewPing(srv.URL + "/ping/abc-123/?create=1")
+	if err != nil {
+		t.Fatalf("NewPing: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := p.Notify(ctx, Event{Type: BackupFailed, Error: "disk full"}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if err := p.Notify(ctx, Event{Type: RetentionPruned}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if err := p.Notify(ctx, Event{Type: BackupSucceeded, Archive: "vaultage-1.tar.age"}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if err := p.Keepalive(ctx, "disk full"); err != nil {
+		t.Fatalf("Keepalive: %v", err)
+	}
+
+	for _, want := range []ping{
+		{"/ping/abc-123/start", "create=1", ""},
+		{"/ping/abc-123/fail", "create=1", "disk full"},
+		{"/ping/abc-123", "create=1", "vaultage-1.tar.age (0 bytes) in 0.0s"},
+		{"/ping/abc-123/fail", "create=1", "disk full"},
+	} {
+		if got := <-pings; got != want {
+			t.Fatalf("got ping %+v, want %+v", got, want)
+		}
+	}
+	if len(pings) != 0 {
+		t.Fatalf("unexpected extra ping: %+v", <-pings)
+	}
+}
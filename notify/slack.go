@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// Sends messages to a Slack incoming webhook using Block Kit.
+type slack struct {
+	webhook *url.URL
+}
+
+func newSlack(u *url.URL, scheme string, q url.Values) (*slack, error) {
+	if err := checkQuery(q); err != nil {
+		return nil, err
+	}
+	webhook := *u
+	webhook.Scheme = scheme
+	webhook.RawQuery = ""
+	return &slack{webhook: &webhook}, nil
+}
+
+func (s *slack) String() string {
+	return "slack " + s.webhook.Host
+}
+
+var slackEmoji = map[Severity]string{SeverityInfo: ":white_check_mark:", SeverityWarning: ":warning:", SeverityError: ":rotating_light:"}
+
+// Matches Markdown bold, which Slack's mrkdwn writes with single asterisks.
+var markdownBold = regexp.MustCompile(`\*\*(.+?)\*\*`)
+
+func (s *slack) send(ctx context.Context, client *http.Client, m Message) error {
+	title := slackEmoji[m.Severity] + " " + m.Title
+	return sendJSON(ctx, client, http.MethodPost, s.webhook.String(), nil, map[string]any{
+		"text": title,
+		"blocks": []map[string]any{
+			{"type": "header", "text": map[string]any{"type": "plain_text", "text": title, "emoji": true}},
+			{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": markdownBold.ReplaceAllString(m.Body, "*$1*")}},
+		},
+	})
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// SMTP settings in Vaultwarden's config.json.
+type vaultwardenConfig struct {
+	Host     *string `json:"smtp_host"`
+	Port     *int    `json:"smtp_port"`
+	Security *string `json:"smtp_security"`
+	Username *string `json:"smtp_username"`
+	Password *string `json:"smtp_password"`
+	From     *string `json:"smtp_from"`
+	FromName *string `json:"smtp_from_name"`
+	Timeout  *int    `json:"smtp_timeout"`
+}
+
+// VaultwardenSMTP reads Vaultwarden's own SMTP settings from the SMTP_*
+// environment variables and config.json in dataDir, which takes
+// precedence like in Vaultwarden.
+func VaultwardenSMTP(dataDir string) (SMTPConfig, error) {
+	cfg := SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Security: os.Getenv("SMTP_SECURITY"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+		FromName: os.Getenv("SMTP_FROM_NAME"),
+	}
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return SMTPConfig{}, fmt.Errorf("invalid SMTP_PORT %q", v)
+		}
+		cfg.Port = port
+	}
+	if v := os.Getenv("SMTP_TIMEOUT"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			return SMTPConfig{}, fmt.Errorf("invalid SMTP_TIMEOUT %q", v)
+		}
+		cfg.Timeout = time.Duration(secs) * time.Second
+	}
+
+	data, err := os.ReadFile(filepath.Join(dataDir, "config.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return SMTPConfig{}, fmt.Errorf("reading Vaultwarden config: %w", err)
+	}
+
+	var vw vaultwardenConfig
+	if err := json.Unmarshal(data, &vw); err != nil {
+		return SMTPConfig{}, fmt.Errorf("decoding Vaultwarden config: %w", err)
+	}
+	override(&cfg.Host, vw.Host)
+	override(&cfg.Port, vw.Port)
+	override(&cfg.Security, vw.Security)
+	override(&cfg.Username, vw.Username)
+	override(&cfg.Password, vw.Password)
+	override(&cfg.From, vw.From)
+	override(&cfg.FromName, vw.FromName)
+	if vw.Timeout != nil {
+		cfg.Timeout = time.Duration(*vw.Timeout) * time.Second
+	}
+
+	return cfg, nil
+}
+
+// Sets *dst to *src if src is set.
+func override[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// Header carrying the HMAC-SHA256 signature of the body, as "sha256=<hex>".
+const signatureHeader = "X-Vaultage-Signature"
+
+// Number of delivery attempts per event.
+const webhookAttempts = 4
+
+// Webhook POSTs events to an HTTP endpoint.
+type Webhook struct {
+	client *http.Client
+	url    string
+	// Secret, if set, signs the body with HMAC-SHA256.
+	secret string
+	header http.Header
+	// Template, if set, renders the body instead of the JSON event.
+	template *template.Template
+}
+
+// WebhookOptions configure a Webhook.
+type WebhookOptions struct {
+	// Secret, if set, signs request bodies with HMAC-SHA256 in the
+	// X-Vaultage-Signature header.
+	Secret string
+	// Header is added to every request.
+	Header http.Header
+	// Template, if set, renders the request body from the Event.
+	Template *template.Template
+}
+
+// NewWebhook returns a webhook notifier for an http or https URL.
+func NewWebhook(rawURL string, opts WebhookOptions) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q: expected an http or https URL", redactURL(rawURL))
+	}
+
+	return &Webhook{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		url:      rawURL,
+		secret:   opts.Secret,
+		header:   opts.Header,
+		template: opts.Template,
+	}, nil
+}
+
+// String returns the webhook host, the URL path often contains a token.
+func (w *Webhook) String() string {
+	return "webhook " + redactURL(w.url)
+}
+
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	body, contentType, err := w.body(e)
+	if err != nil {
+		return err
+	}
+
+	return withRetries(ctx, webhookAttempts, func() error {
+		return w.post(ctx, e, body, contentType)
+	})
+}
+
+// Renders the request body for e.
+func (w *Webhook) body(e Event) ([]byte, string, error) {
+	if w.template == nil {
+		body, err := json.Marshal(e)
+		return body, "application/json", err
+	}
+
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, e); err != nil {
+		return nil, "", fmt.Errorf("rendering webhook template: %w", err)
+	}
+	contentType := "text/plain; charset=utf-8"
+	if json.Valid(buf.Bytes()) {
+		contentType = "application/json"
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+func (w *Webhook) post(ctx context.Context, e Event, body []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "vaultage")
+	req.Header.Set("X-Vaultage-Event", string(e.Type))
+	for k, v := range w.header {
+		req.Header[k] = v
+	}
+	if w.secret != "" {
+		req.Header.Set(signatureHeader, "sha256="+Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		// url.Error repeats the full URL, which may contain a token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("posting to %s: %w", redactURL(w.url), err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status))
+	}
+	return nil
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 of body with secret, as sent
+// in the X-Vaultage-Signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the scheme and host of rawURL, dropping the path, query and
+// credentials that may contain tokens.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "<invalid URL>"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook_SignsAndRetries(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = time.Second })
+
+	var calls atomic.Int32
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	w, err := NewWebhook(srv.URL+"/hook/token", WebhookOptions{
+		Secret: "s3cret",
+		Header: http.Header{"Authorization": {"Bearer abc"}},
+	})
+	if err != nil {
+		t.Fatalf("NewWebhook: %v", err)
+	}
+	if strings.Contains(w.String(), "token") {
+		t.Fatalf("String leaks the URL path: %s", w)
+	}
+
+	event := Event{Type: BackupFailed, Archive: "vaultage-1.tar.age", Error: "disk full"}
+	if err := w.Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	r, body := <-received, <-bodies
+	if calls.Load() != 2 {
+		t.Fatalf("expected one retry, got %d calls", calls.Load())
+	}
+	if got := r.Header.Get(signatureHeader); got != "sha256="+Sign("s3cret", body) {
+		t.Fatalf("unexpected signature %q", got)
+	}
+	if r.Header.Get("Authorization") != "Bearer abc" || r.Header.Get("X-Vaultage-Event") != "backup.failure" {
+		t.Fatalf("unexpected headers: %v", r.Header)
+	}
+
+	var got Event
+	if err := json.Unmarshal(body, &got); err != nil || got.Error != "disk full" {
+		t.Fatalf("unexpected body %s: %v", body, err)
+	}
+}
+
+func TestWebhook_ClientErrorsArePermanent(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = time.Second })
+
+	tests := []struct {
+		status int
+		calls  int32
+	}{
+		{http.StatusUnauthorized, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusTooManyRequests, webhookAttempts},
+		{http.StatusRequestTimeout, webhookAttempts},
+		{http.StatusServiceUnavailable, webhookAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			w, err := NewWebhook(srv.URL, WebhookOptions{})
+			if err != nil {
+				t.Fatalf("NewWebhook: %v", err)
+			}
+			if err := w.Notify(context.Background(), Event{Type: BackupFailed}); err == nil {
+				t.Fatal("expected an error")
+			}
+			if calls.Load() != tt.calls {
+				t.Fatalf("got %d calls, want %d", calls.Load(), tt.calls)
+			}
+		})
+	}
+}
+
+func TestWebhook_Template(t *testing.T) {
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- r.Header.Get("Content-Type") + " " + string(body)
+	}))
+	defer srv.Close()
+
+	tmpl, err := ParseTemplate("test", `{"text": {{ json (printf "%s: %s" .Type .Error) }}}`)
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	w, err := NewWebhook(srv.URL, WebhookOptions{Template: tmpl})
+	if err != nil {
+		t.Fatalf("NewWebhook: %v", err)
+	}
+
+	if err := w.Notify(context.Background(), Event{Type: BackupFailed, Error: `"quoted"`}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if got, want := <-bodies, `application/json {"text": "backup.failure: \"quoted\""}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
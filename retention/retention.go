@@ -0,0 +1,144 @@
+// Package retention decides which backup archives to keep in a destination.
+package retention
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mijolabs/vaultage/destination"
+)
+
+// Policy keeps the newest archives plus the newest archive of each of the
+// most recent hours, days, weeks and months, similar to restic's forget.
+// An archive kept by any rule is kept. The zero Policy keeps everything.
+type Policy struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Query parameters that configure a Policy on a destination URL.
+var policyParams = []string{"keep-last", "keep-hourly", "keep-daily", "keep-weekly", "keep-monthly"}
+
+// IsZero reports whether the policy keeps everything.
+func (p Policy) IsZero() bool {
+	return p == Policy{}
+}
+
+func (p Policy) String() string {
+	if p.IsZero() {
+		return "keep all"
+	}
+	var parts []string
+	for _, rule := range []struct {
+		name string
+		n    int
+	}{{"last", p.Last}, {"hourly", p.Hourly}, {"daily", p.Daily}, {"weekly", p.Weekly}, {"monthly", p.Monthly}} {
+		if rule.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", rule.n, rule.name))
+		}
+	}
+	return "keep " + strings.Join(parts, ", ")
+}
+
+// SplitURL removes the keep-* query parameters from a destination URL or
+// plain path and returns the remaining URL and the policy they describe.
+func SplitURL(rawURL string) (string, Policy, error) {
+	base, rawQuery, found := strings.Cut(rawURL, "?")
+	if !found {
+		return rawURL, Policy{}, nil
+	}
+
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", Policy{}, fmt.Errorf("parsing destination query: %w", err)
+	}
+
+	var p Policy
+	for _, param := range policyParams {
+		v := q.Get(param)
+		q.Del(param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return "", Policy{}, fmt.Errorf("invalid %s %q: expected a non-negative number", param, v)
+		}
+		switch param {
+		case "keep-last":
+			p.Last = n
+		case "keep-hourly":
+			p.Hourly = n
+		case "keep-daily":
+			p.Daily = n
+		case "keep-weekly":
+			p.Weekly = n
+		case "keep-monthly":
+			p.Monthly = n
+		}
+	}
+
+	if len(q) > 0 {
+		base += "?" + q.Encode()
+	}
+	return base, p, nil
+}
+
+// Select splits objects into those the policy keeps and those to prune.
+// Both results are sorted newest first.
+func (p Policy) Select(objects []destination.Object) (keep, prune []destination.Object) {
+	sorted := make([]destination.Object, len(objects))
+	copy(sorted, objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ModTime.After(sorted[j].ModTime)
+	})
+
+	if p.IsZero() {
+		return sorted, nil
+	}
+
+	buckets := []struct {
+		remaining int
+		period    func(time.Time) string
+		last      string
+	}{
+		{remaining: p.Hourly, period: func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{remaining: p.Daily, period: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{remaining: p.Weekly, period: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{remaining: p.Monthly, period: func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	for i, obj := range sorted {
+		kept := i < p.Last
+		for b := range buckets {
+			bucket := &buckets[b]
+			if bucket.remaining == 0 {
+				continue
+			}
+			// Objects are visited newest first, so the first object of
+			// every period is the newest one in it
+			if period := bucket.period(obj.ModTime.Local()); period != bucket.last {
+				bucket.last = period
+				bucket.remaining--
+				kept = true
+			}
+		}
+		if kept {
+			keep = append(keep, obj)
+		} else {
+			prune = append(prune, obj)
+		}
+	}
+
+	return keep, prune
+}
@@ -0,0 +1,75 @@
+package retention
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mijolabs/vaultage/destination"
+)
+
+// Builds one object per hour, newest first, ending at end.
+func hourlyObjects(end time.Time, n int) []destination.Object {
+	objects := make([]destination.Object, n)
+	for i := range objects {
+		objects[i] = destination.Object{
+			Name:    fmt.Sprintf("vaultage-%d.tar.age", i),
+			ModTime: end.Add(-time.Duration(i) * time.Hour),
+		}
+	}
+	return objects
+}
+
+func TestPolicy_Select(t *testing.T) {
+	end := time.Date(2026, 3, 10, 23, 30, 0, 0, time.Local)
+	objects := hourlyObjects(end, 96)
+
+	tests := []struct {
+		policy Policy
+		keep   int
+	}{
+		{Policy{}, 96},
+		{Policy{Last: 5}, 5},
+		{Policy{Hourly: 48}, 48},
+		{Policy{Daily: 3}, 3},
+		// The newest 24 hourly archives include the newest archive of the first day
+		{Policy{Hourly: 24, Daily: 3}, 26},
+		{Policy{Last: 200}, 96},
+	}
+
+	for _, tt := range tests {
+		keep, prune := tt.policy.Select(objects)
+		if len(keep) != tt.keep || len(keep)+len(prune) != len(objects) {
+			t.Errorf("%s: expected %d kept, got %d kept and %d pruned", tt.policy, tt.keep, len(keep), len(prune))
+		}
+		if len(keep) > 0 && keep[0].Name != "vaultage-0.tar.age" {
+			t.Errorf("%s: newest archive not kept", tt.policy)
+		}
+	}
+}
+
+func TestSplitURL(t *testing.T) {
+	tests := []struct {
+		raw    string
+		url    string
+		policy Policy
+	}{
+		{"/backups", "/backups", Policy{}},
+		{"/backups?keep-last=48", "/backups", Policy{Last: 48}},
+		{"s3://bucket/prefix?region=eu&keep-daily=90&keep-monthly=12", "s3://bucket/prefix?region=eu", Policy{Daily: 90, Monthly: 12}},
+	}
+
+	for _, tt := range tests {
+		u, policy, err := SplitURL(tt.raw)
+		if err != nil {
+			t.Fatalf("SplitURL(%q): %v", tt.raw, err)
+		}
+		if u != tt.url || policy != tt.policy {
+			t.Errorf("SplitURL(%q): got %q %+v, expected %q %+v", tt.raw, u, policy, tt.url, tt.policy)
+		}
+	}
+
+	if _, _, err := SplitURL("/backups?keep-last=many"); err == nil {
+		t.Fatal("expected error for invalid keep-last")
+	}
+}
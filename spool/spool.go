@@ -0,0 +1,246 @@
+// Package spool keeps finished archives on local disk until they have
+// been uploaded to destinations that were unreachable.
+package spool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is an archive waiting to be uploaded to one destination.
+type Entry struct {
+	ID string `json:"id"`
+	// Destination identifies the target, see destination.Destination.String.
+	Destination string    `json:"destination"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Spool is a persistent upload queue in a local directory. Archive data is
+// stored in one file per entry and the queue itself in a JSON state file,
+// so pending uploads survive restarts.
+type Spool struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// Name of the state file inside the spool directory.
+const stateFileName = "state.json"
+
+// Open returns the spool in dir. The directory is only created once an
+// archive is added. When the spool would grow beyond maxSize bytes, the
+// oldest entries are dropped.
+func Open(dir string, maxSize int64) (*Spool, error) {
+	s := &Spool{dir: dir, maxSize: maxSize}
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Dir returns the spool directory.
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Add queues data for upload to dest under filename. The first attempt is due immediately.
+func (s *Spool) Add(dest, filename string, data []byte) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(len(data))
+	if size > s.maxSize {
+		return Entry{}, fmt.Errorf("archive of %d bytes exceeds the spool size limit of %d bytes", size, s.maxSize)
+	}
+
+	entries, err := s.load()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	// Make room by dropping the oldest entries
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	for len(entries) > 0 && total+size > s.maxSize {
+		oldest := entries[0]
+		slog.Warn("spool full, dropping oldest entry", "archive", oldest.Filename, "destination", oldest.Destination, "bytes", oldest.Size)
+		os.Remove(s.dataPath(oldest.ID))
+		total -= oldest.Size
+		entries = entries[1:]
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return Entry{}, fmt.Errorf("creating spool directory: %w", err)
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	now := time.Now()
+	entry := Entry{
+		ID:          hex.EncodeToString(id),
+		Destination: dest,
+		Filename:    filename,
+		Size:        size,
+		Created:     now,
+		NextAttempt: now,
+	}
+
+	if err := writeFileAtomic(s.dataPath(entry.ID), data); err != nil {
+		return Entry{}, fmt.Errorf("writing spool data: %w", err)
+	}
+	entries = append(entries, entry)
+	if err := s.save(entries); err != nil {
+		os.Remove(s.dataPath(entry.ID))
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// Entries returns all queued entries, oldest first.
+func (s *Spool) Entries() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Due returns the entries whose next attempt is at or before now.
+func (s *Spool) Due(now time.Time) ([]Entry, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var due []Entry
+	for _, e := range entries {
+		if !e.NextAttempt.After(now) {
+			due = append(due, e)
+		}
+	}
+	return due, nil
+}
+
+// Path returns the file holding the archive data of e.
+func (s *Spool) Path(e Entry) string {
+	return s.dataPath(e.ID)
+}
+
+// Done removes an entry after its upload was confirmed.
+func (s *Spool) Done(id string) error {
+	return s.update(id, func(entries []Entry, i int) []Entry {
+		os.Remove(s.dataPath(id))
+		return append(entries[:i], entries[i+1:]...)
+	})
+}
+
+// Failed records a failed upload attempt and when to try again.
+func (s *Spool) Failed(id string, uploadErr error, next time.Time) error {
+	return s.update(id, func(entries []Entry, i int) []Entry {
+		entries[i].Attempts++
+		entries[i].LastError = uploadErr.Error()
+		entries[i].NextAttempt = next
+		return entries
+	})
+}
+
+// Applies fn to the entry with the given id and saves the result.
+func (s *Spool) update(id string, fn func(entries []Entry, i int) []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if entries[i].ID == id {
+			return s.save(fn(entries, i))
+		}
+	}
+	return fmt.Errorf("spool entry %s not found", id)
+}
+
+func (s *Spool) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".archive")
+}
+
+// Reads the state file. Entries whose data file is gone are dropped.
+func (s *Spool) load() ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading spool state: %w", err)
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing spool state: %w", err)
+	}
+
+	valid := entries[:0]
+	for _, e := range entries {
+		if _, err := os.Stat(s.dataPath(e.ID)); err != nil {
+			slog.Warn("spool data file missing, dropping entry", "archive", e.Filename, "destination", e.Destination, "path", s.dataPath(e.ID))
+			continue
+		}
+		valid = append(valid, e)
+	}
+
+	sort.SliceStable(valid, func(i, j int) bool {
+		return valid[i].Created.Before(valid[j].Created)
+	})
+	return valid, nil
+}
+
+func (s *Spool) save(entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding spool state: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, stateFileName), data); err != nil {
+		return fmt.Errorf("writing spool state: %w", err)
+	}
+	return nil
+}
+
+// Writes data to a temp file next to path and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
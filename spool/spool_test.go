@@ -0,0 +1,91 @@
+package spool
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpool_Lifecycle(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+
+	s, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected Open not to create the directory, got %v", err)
+	}
+
+	entry, err := s.Add("s3://bucket", "vaultage-1.tar.age", []byte("archive"))
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	due, err := s.Due(time.Now())
+	if err != nil || len(due) != 1 || due[0].ID != entry.ID {
+		t.Fatalf("expected entry to be due, got %+v, %v", due, err)
+	}
+
+	next := time.Now().Add(time.Hour)
+	if err := s.Failed(entry.ID, errors.New("timeout"), next); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	// Reopening reads the persisted state
+	s, err = Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	entries, err := s.Entries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one persisted entry, got %+v, %v", entries, err)
+	}
+	if entries[0].Attempts != 1 || entries[0].LastError != "timeout" {
+		t.Fatalf("failure not recorded: %+v", entries[0])
+	}
+	if due, _ := s.Due(time.Now()); len(due) != 0 {
+		t.Fatalf("expected no due entries before next attempt, got %+v", due)
+	}
+
+	data, err := os.ReadFile(s.Path(entries[0]))
+	if err != nil || string(data) != "archive" {
+		t.Fatalf("unexpected spooled data: %q, %v", data, err)
+	}
+
+	if err := s.Done(entry.ID); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+	if entries, _ := s.Entries(); len(entries) != 0 {
+		t.Fatalf("expected empty spool, got %+v", entries)
+	}
+	if _, err := os.Stat(s.Path(entry)); !os.IsNotExist(err) {
+		t.Fatalf("expected data file to be removed, got %v", err)
+	}
+}
+
+func TestSpool_MaxSize(t *testing.T) {
+	s, err := Open(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if _, err := s.Add("sftp://nas", "vaultage-1.tar.age", []byte("123456")); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	// Does not fit next to the first entry, which is dropped
+	if _, err := s.Add("sftp://nas", "vaultage-2.tar.age", []byte("123456")); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	entries, err := s.Entries()
+	if err != nil || len(entries) != 1 || entries[0].Filename != "vaultage-2.tar.age" {
+		t.Fatalf("expected only the newest entry, got %+v, %v", entries, err)
+	}
+
+	if _, err := s.Add("sftp://nas", "vaultage-3.tar.age", make([]byte, 11)); err == nil {
+		t.Fatal("expected error for archive larger than the spool")
+	}
+}
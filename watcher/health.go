@@ -0,0 +1,91 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HeartbeatInterval is how often the watcher loop refreshes Status.Heartbeat.
+const HeartbeatInterval = 30 * time.Second
+
+// A heartbeat older than this means the watcher loop is stuck.
+const heartbeatTimeout = 3 * HeartbeatInterval
+
+// Check returns why the watcher is unhealthy at now, or nil. It is
+// unhealthy when its loop stopped responding or the last backup failed.
+// With maxAge set, it is also unhealthy when the newest successful backup
+// is older than maxAge. Before the first success, the start time counts.
+func (s Status) Check(now time.Time, maxAge time.Duration) error {
+	if s.Heartbeat.IsZero() {
+		return fmt.Errorf("watcher has not started")
+	}
+	if age := now.Sub(s.Heartbeat); age > heartbeatTimeout {
+		return fmt.Errorf("watcher is unresponsive, last heartbeat %s ago", age.Round(time.Second))
+	}
+
+	if s.LastError != "" {
+		return fmt.Errorf("last backup failed (%d attempt(s)): %s", s.RetryAttempt, s.LastError)
+	}
+
+	if maxAge > 0 {
+		last := s.LastSuccess
+		if last.IsZero() {
+			last = s.Started
+		}
+		if age := now.Sub(last); age > maxAge {
+			if s.LastSuccess.IsZero() {
+				return fmt.Errorf("no successful backup since start %s ago", age.Round(time.Second))
+			}
+			return fmt.Errorf("newest successful backup is %s old", age.Round(time.Second))
+		}
+	}
+
+	return nil
+}
+
+// WriteStatusFile atomically replaces the status file at path.
+func WriteStatusFile(path string, s Status) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("creating status directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".status-*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing status file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing status file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing status file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing status file: %w", err)
+	}
+	return nil
+}
+
+// ReadStatusFile reads a status file written by WriteStatusFile.
+func ReadStatusFile(path string) (Status, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Status{}, fmt.Errorf("reading status file: %w", err)
+	}
+
+	var s Status
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Status{}, fmt.Errorf("decoding status file %s: %w", path, err)
+	}
+	return s, nil
+}
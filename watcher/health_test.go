@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusCheck(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	healthy := Status{
+		Started:     now.Add(-48 * time.Hour),
+		Heartbeat:   now.Add(-10 * time.Second),
+		LastSuccess: now.Add(-2 * time.Hour),
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Status)
+		maxAge time.Duration
+		want   string
+	}{
+		{"healthy", func(*Status) {}, 0, ""},
+		{"within max age", func(*Status) {}, 3 * time.Hour, ""},
+		{"not started", func(s *Status) { s.Heartbeat = time.Time{} }, 0, "not started"},
+		{"stale heartbeat", func(s *Status) { s.Heartbeat = now.Add(-5 * time.Minute) }, 0, "unresponsive"},
+		{"failed", func(s *Status) { s.LastError = "disk full"; s.RetryAttempt = 2 }, 0, "disk full"},
+		{"too old", func(*Status) {}, time.Hour, "2h0m0s old"},
+		{"no success yet, recent start", func(s *Status) {
+			s.LastSuccess = time.Time{}
+			s.Started = now.Add(-time.Minute)
+		}, time.Hour, ""},
+		{"no success since start", func(s *Status) { s.LastSuccess = time.Time{} }, time.Hour, "since start"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := healthy
+			tt.modify(&s)
+			err := s.Check(now, tt.maxAge)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("expected healthy, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "status.json")
+	want := Status{
+		Unbacked:  true,
+		Started:   time.Now().Truncate(time.Second),
+		Heartbeat: time.Now().Truncate(time.Second),
+		LastError: "connection refused",
+	}
+
+	if err := WriteStatusFile(path, want); err != nil {
+		t.Fatalf("WriteStatusFile: %v", err)
+	}
+	got, err := ReadStatusFile(path)
+	if err != nil {
+		t.Fatalf("ReadStatusFile: %v", err)
+	}
+	if !got.Started.Equal(want.Started) || got.LastError != want.LastError || !got.Unbacked {
+		t.Fatalf("status did not round-trip: got %+v, want %+v", got, want)
+	}
+}
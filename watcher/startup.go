@@ -0,0 +1,61 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/history"
+)
+
+// Decides whether a backup should be taken when the watcher starts, and why.
+//
+// Without conditions a backup is always taken. With a max age the newest
+// existing backup must be older than it, and with ifChanged a snapshot of
+// the live database must differ from the one of the last successful
+// backup, going by the digests in the run history. When both conditions
+// are set, either one is enough.
+func startupBackupReason(ctx context.Context, cfg Config) (string, error) {
+	// The first target is the primary one, usually local
+	latest, found, err := backup.LatestBackup(ctx, cfg.OutputTargets()[0])
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "no existing backup", nil
+	}
+
+	if cfg.BackupOnStartMaxAge <= 0 && !cfg.BackupOnStartIfChanged {
+		return "backup on start", nil
+	}
+
+	if cfg.BackupOnStartMaxAge > 0 {
+		if age := time.Since(latest.ModTime); age > cfg.BackupOnStartMaxAge {
+			return fmt.Sprintf("newest backup %s is %s old", latest.Name, age.Round(time.Second)), nil
+		}
+	}
+
+	if cfg.BackupOnStartIfChanged {
+		var last history.Record
+		if cfg.History != nil {
+			if last, _, err = cfg.History.LastSuccess(); err != nil {
+				return "", err
+			}
+		}
+		// Runs recorded by earlier versions have no digest
+		if last.DatabaseSHA256 == "" {
+			return "no database digest recorded for the last successful backup", nil
+		}
+
+		digest, err := backup.DatabaseDigest(ctx, cfg.Config)
+		if err != nil {
+			return "", err
+		}
+		if digest != last.DatabaseSHA256 {
+			return fmt.Sprintf("database changed since %s", last.Archive), nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,55 @@
+package watcher
+
+import "time"
+
+// Status is a snapshot of the watcher's backup state.
+type Status struct {
+	// Unbacked is set while a detected change has not been captured
+	// by a successful backup yet.
+	Unbacked bool `json:"unbacked"`
+	// UnbackedSince is when the oldest uncaptured change was detected.
+	UnbackedSince time.Time `json:"unbacked_since,omitzero"`
+	// Running is set while a backup is in progress.
+	Running bool `json:"running"`
+	// RetryAttempt counts consecutive failed backups. Zero after a success.
+	RetryAttempt int `json:"retry_attempt"`
+	// NextRetry is when the next retry is scheduled, if any.
+	NextRetry time.Time `json:"next_retry,omitzero"`
+	// LastAttempt is when the most recent backup finished.
+	LastAttempt time.Time `json:"last_attempt,omitzero"`
+	// LastSuccess is when the most recent successful backup finished.
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	// LastError is the error of the most recent backup, empty on success.
+	LastError string `json:"last_error,omitempty"`
+	// Started is when the watcher started.
+	Started time.Time `json:"started,omitzero"`
+	// Heartbeat is refreshed by the watcher loop every HeartbeatInterval,
+	// so a stale heartbeat means the loop is stuck.
+	Heartbeat time.Time `json:"heartbeat,omitzero"`
+}
+
+// Upper bound for the delay between retries when MaxBackoff is not set,
+// which also keeps the doubling from overflowing.
+const retryBackoffCeiling = 24 * time.Hour
+
+// RetryConfig controls how failed backups are retried.
+type RetryConfig struct {
+	// Backoff is the delay before the first retry. It doubles after every
+	// further failure. Zero disables retries.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries. Zero caps it at a day.
+	MaxBackoff time.Duration
+}
+
+// Returns the delay before the given retry attempt (1-based).
+func (r RetryConfig) delay(attempt int) time.Duration {
+	ceiling := retryBackoffCeiling
+	if r.MaxBackoff > 0 {
+		ceiling = r.MaxBackoff
+	}
+	d := r.Backoff
+	for i := 1; i < attempt && d < ceiling; i++ {
+		d *= 2
+	}
+	return min(d, ceiling)
+}
@@ -3,20 +3,48 @@ package watcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 
 	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/history"
+	"github.com/mijolabs/vaultage/logging"
 )
 
 // Config holds the configuration needed for the watcher.
 type Config struct {
 	backup.Config
 	Debounce time.Duration
+	// BackupOnStart takes a backup as soon as the watcher starts.
+	BackupOnStart bool
+	// BackupOnStartMaxAge limits the startup backup to when the newest
+	// existing backup is older than this. Zero means no age limit.
+	BackupOnStartMaxAge time.Duration
+	// BackupOnStartIfChanged limits the startup backup to when a snapshot
+	// of the live database differs from the one of the last successful
+	// backup in the run history.
+	BackupOnStartIfChanged bool
+	// Retry controls how failed backups are retried.
+	Retry RetryConfig
+	// OnStatus, if set, receives a copy of the status whenever it changes.
+	// It is called from the watcher loop and must not block.
+	OnStatus func(Status)
+	// StatusFile, if set, is kept up to date with the status for
+	// out-of-process health checks, see ReadStatusFile.
+	StatusFile string
+	// OnBackupStart, if set, is called before every backup run, from the
+	// goroutine running the backup.
+	OnBackupStart func()
+	// OnBackup, if set, is called with the outcome of every backup run.
+	// It is called from the goroutine running the backup.
+	OnBackup func(backup.Result, error)
 }
 
 // WalFileName is the SQLite write-ahead log file that indicates database changes.
@@ -28,8 +56,8 @@ const WalFileName = "db.sqlite3-wal"
 func Watch(ctx context.Context, cfg Config) error {
 	walFilePath := cfg.DataDir + "/" + WalFileName
 
-	log.Printf("watching %s (debounce: %s)", walFilePath, cfg.Debounce)
-	log.Printf("exclude attachments: %t", cfg.ExcludeAttachments)
+	slog.InfoContext(ctx, "watching for database changes", "path", walFilePath, "debounce", cfg.Debounce,
+		"exclude_attachments", cfg.ExcludeAttachments)
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -41,11 +69,98 @@ func Watch(ctx context.Context, cfg Config) error {
 		return fmt.Errorf("adding watch on data directory: %w", err)
 	}
 
-	backupFn := func() error {
-		return backup.Perform(ctx, cfg.Config)
+	backupFn := func(trigger history.Trigger) error {
+		if cfg.OnBackupStart != nil {
+			cfg.OnBackupStart()
+		}
+		runCfg := cfg.Config
+		runCfg.Trigger = trigger
+		result, err := backup.Perform(ctx, runCfg)
+		if cfg.OnBackup != nil {
+			cfg.OnBackup(result, err)
+		}
+		return err
+	}
+
+	l := newLoop(watcher, cfg.DataDir, cfg.Debounce, backupFn)
+	l.retry = cfg.Retry
+	l.onStatus = statusPublisher(ctx, cfg)
+
+	if cfg.History != nil {
+		// Report the last good backup from before a restart
+		if last, ok, err := cfg.History.LastSuccess(); err != nil {
+			slog.WarnContext(ctx, "reading backup history failed", "path", cfg.History.Path(), logging.Err(err))
+		} else if ok {
+			l.status.LastSuccess = last.Finished
+		}
+	}
+
+	if cfg.Spool != nil {
+		go flushSpool(ctx, cfg.Config)
+	}
+
+	if cfg.BackupOnStart {
+		reason, err := startupBackupReason(ctx, cfg)
+		if err != nil {
+			slog.WarnContext(ctx, "checking existing backups failed", logging.Err(err))
+			reason = "existing backups could not be checked"
+		}
+
+		if reason == "" {
+			slog.InfoContext(ctx, "skipping startup backup, newest backup is recent and up to date")
+		} else {
+			slog.InfoContext(ctx, "performing startup backup", "reason", reason)
+			l.markUnbacked()
+			l.startBackup(history.TriggerStartup)
+		}
 	}
 
-	return runLoop(ctx, watcher, cfg.Debounce, backupFn)
+	return l.run(ctx)
+}
+
+// Returns the function receiving status updates, which writes the status
+// file and forwards to cfg.OnStatus.
+func statusPublisher(ctx context.Context, cfg Config) func(Status) {
+	if cfg.StatusFile == "" {
+		return cfg.OnStatus
+	}
+
+	var failing bool
+	return func(s Status) {
+		if err := WriteStatusFile(cfg.StatusFile, s); err != nil {
+			// Only log the first of a series of failures, the heartbeat
+			// would repeat it every HeartbeatInterval
+			if !failing {
+				slog.ErrorContext(ctx, "updating status file failed", "path", cfg.StatusFile, logging.Err(err))
+			}
+			failing = true
+		} else {
+			failing = false
+		}
+
+		if cfg.OnStatus != nil {
+			cfg.OnStatus(s)
+		}
+	}
+}
+
+// How often the spool is checked for uploads that are due.
+const spoolFlushInterval = 30 * time.Second
+
+// Retries spooled uploads until the context is cancelled.
+func flushSpool(ctx context.Context, cfg backup.Config) {
+	ticker := time.NewTicker(spoolFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		backup.FlushSpool(ctx, cfg.Spool, cfg.OutputTargets())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // logCooldown suppresses repeated log messages within this duration.
@@ -54,36 +169,106 @@ func Watch(ctx context.Context, cfg Config) error {
 // noisy logs while still resetting the debounce timer for each event.
 const logCooldown = 1 * time.Second
 
-// runLoop processes file system events and triggers backups after debounce.
-func runLoop(ctx context.Context, watcher *fsnotify.Watcher, debounce time.Duration, backupFn func() error) error {
-	var debounceTimer *time.Timer
-	var lastLogTime time.Time
+// Bounds for the exponential backoff used while re-establishing a lost watch.
+var (
+	rewatchMinBackoff = 1 * time.Second
+	rewatchMaxBackoff = 1 * time.Minute
+)
+
+// loop owns the watcher state. All fields are only touched from the
+// goroutine running loop.run, backups run on their own goroutine and
+// report back through done.
+type loop struct {
+	watcher  *fsnotify.Watcher
+	dataDir  string
+	debounce time.Duration
+	backupFn func(history.Trigger) error
+	retry    RetryConfig
+	onStatus func(Status)
+
+	status        Status
+	debounceTimer *time.Timer
+	// What started the debounce timer.
+	debounceTrigger history.Trigger
+	retryTimer      *time.Timer
+	// Set to the trigger of a backup requested while another one was
+	// running.
+	pending history.Trigger
+	// Set when a change was detected while a backup was running, so its
+	// success does not clear the unbacked state.
+	changedDuringRun bool
+	done             chan error
+	lastLogTime      time.Time
+}
+
+func newLoop(watcher *fsnotify.Watcher, dataDir string, debounce time.Duration, backupFn func(history.Trigger) error) *loop {
+	debounceTimer := time.NewTimer(debounce)
+	debounceTimer.Stop()
+	retryTimer := time.NewTimer(0)
+	retryTimer.Stop()
+
+	now := time.Now()
+	return &loop{
+		status:        Status{Started: now, Heartbeat: now},
+		watcher:       watcher,
+		dataDir:       filepath.Clean(dataDir),
+		debounce:      debounce,
+		backupFn:      backupFn,
+		debounceTimer: debounceTimer,
+		retryTimer:    retryTimer,
+		done:          make(chan error, 1),
+	}
+}
+
+// run processes file system events and triggers backups after debounce.
+// Failed backups are retried with exponential backoff until one succeeds.
+// When the watch on the data directory is lost, because the directory was
+// removed or renamed or the event queue overflowed, it is re-established
+// and a safety backup is scheduled since changes may have been missed.
+func (l *loop) run(ctx context.Context) error {
+	defer l.debounceTimer.Stop()
+	defer l.retryTimer.Stop()
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	l.publishStatus()
 
 	for {
 		select {
 		case <-ctx.Done():
-			if debounceTimer != nil {
-				debounceTimer.Stop()
-			}
 			return ctx.Err()
 
-		case err, ok := <-watcher.Errors:
+		case now := <-heartbeat.C:
+			l.status.Heartbeat = now
+			l.publishStatus()
+
+		case err, ok := <-l.watcher.Errors:
 			if !ok {
-				if debounceTimer != nil {
-					debounceTimer.Stop()
-				}
 				return nil
 			}
-			log.Printf("watcher error: %v", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				slog.WarnContext(ctx, "watcher event queue overflowed, events may have been lost", "path", l.dataDir)
+			} else {
+				slog.ErrorContext(ctx, "watcher error", "path", l.dataDir, logging.Err(err))
+			}
+			if err := l.recoverWatch(ctx); err != nil {
+				return err
+			}
 
-		case event, ok := <-watcher.Events:
+		case event, ok := <-l.watcher.Events:
 			if !ok {
-				if debounceTimer != nil {
-					debounceTimer.Stop()
-				}
 				return nil
 			}
 
+			if filepath.Clean(event.Name) == l.dataDir && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				slog.WarnContext(ctx, "data directory was removed or renamed", "path", l.dataDir, "op", event.Op.String())
+				if err := l.recoverWatch(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+
 			if !strings.HasSuffix(event.Name, WalFileName) {
 				continue
 			}
@@ -91,20 +276,151 @@ func runLoop(ctx context.Context, watcher *fsnotify.Watcher, debounce time.Durat
 				continue
 			}
 
-			if debounceTimer != nil {
-				debounceTimer.Stop()
+			l.markUnbacked()
+			l.debounceTrigger = history.TriggerWAL
+			l.debounceTimer.Reset(l.debounce)
+
+			if time.Since(l.lastLogTime) >= logCooldown {
+				slog.InfoContext(ctx, "detected change in WAL file, backup scheduled",
+					"path", event.Name, "op", event.Op.String(), "debounce", l.debounce)
+				l.lastLogTime = time.Now()
 			}
 
-			debounceTimer = time.AfterFunc(debounce, func() {
-				if err := backupFn(); err != nil {
-					log.Printf("backup error: %v", err)
-				}
-			})
+		case <-l.debounceTimer.C:
+			l.startBackup(l.debounceTrigger)
 
-			if time.Since(lastLogTime) >= logCooldown {
-				log.Printf("detected change in WAL file: %s (%s) - backup scheduled in %s", event.Name, event.Op.String(), debounce)
-				lastLogTime = time.Now()
-			}
+		case <-l.retryTimer.C:
+			l.status.NextRetry = time.Time{}
+			slog.InfoContext(ctx, "retrying backup", "attempt", l.status.RetryAttempt+1)
+			l.startBackup(history.TriggerRetry)
+
+		case err := <-l.done:
+			l.finishBackup(ctx, err)
+		}
+	}
+}
+
+// Records that a change needs to be captured by the next backup.
+func (l *loop) markUnbacked() {
+	if l.status.Running {
+		l.changedDuringRun = true
+	}
+	if !l.status.Unbacked {
+		l.status.Unbacked = true
+		l.status.UnbackedSince = time.Now()
+		l.publishStatus()
+	}
+}
+
+// Starts a backup in the background, or queues one if a backup is running.
+func (l *loop) startBackup(trigger history.Trigger) {
+	if l.status.Running {
+		l.pending = trigger
+		return
+	}
+
+	l.status.Running = true
+	l.changedDuringRun = false
+	l.publishStatus()
+
+	go func() {
+		l.done <- l.backupFn(trigger)
+	}()
+}
+
+// Updates the status after a backup finished and schedules a retry on failure.
+func (l *loop) finishBackup(ctx context.Context, err error) {
+	now := time.Now()
+	l.status.Running = false
+	l.status.LastAttempt = now
+
+	if err != nil {
+		l.status.RetryAttempt++
+		l.status.LastError = err.Error()
+
+		if l.retry.Backoff > 0 {
+			delay := l.retry.delay(l.status.RetryAttempt)
+			l.status.NextRetry = now.Add(delay)
+			l.retryTimer.Reset(delay)
+			slog.WarnContext(ctx, "backup retry scheduled", "failures", l.status.RetryAttempt,
+				"unbacked_since", l.status.UnbackedSince, "delay", delay)
+		}
+	} else {
+		if l.status.RetryAttempt > 0 {
+			slog.InfoContext(ctx, "backup succeeded after failed attempts", "failures", l.status.RetryAttempt)
+		}
+		l.retryTimer.Stop()
+		l.status.RetryAttempt = 0
+		l.status.NextRetry = time.Time{}
+		l.status.LastError = ""
+		l.status.LastSuccess = now
+		if !l.changedDuringRun {
+			l.status.Unbacked = false
+			l.status.UnbackedSince = time.Time{}
 		}
 	}
+
+	l.publishStatus()
+
+	if l.pending != "" {
+		trigger := l.pending
+		l.pending = ""
+		l.startBackup(trigger)
+	}
+}
+
+func (l *loop) publishStatus() {
+	if l.onStatus != nil {
+		l.onStatus(l.status)
+	}
+}
+
+// Re-establishes the watch on the data directory and schedules a safety backup.
+func (l *loop) recoverWatch(ctx context.Context) error {
+	if err := rewatch(ctx, l.watcher, l.dataDir); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "watch re-established, safety backup scheduled", "path", l.dataDir, "debounce", l.debounce)
+	l.markUnbacked()
+	l.debounceTrigger = history.TriggerRecovery
+	l.debounceTimer.Reset(l.debounce)
+	return nil
+}
+
+// Drops any stale watch on dir and adds it again, retrying with exponential
+// backoff until the directory is back or the context is cancelled.
+func rewatch(ctx context.Context, watcher *fsnotify.Watcher, dir string) error {
+	// The kernel drops the watch itself when the directory is removed,
+	// so a missing watch is expected here
+	if err := watcher.Remove(dir); err != nil && !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+		slog.WarnContext(ctx, "removing stale watch failed", "path", dir, logging.Err(err))
+	}
+
+	backoff := rewatchMinBackoff
+	for attempt := 1; ; attempt++ {
+		err := addDirWatch(watcher, dir)
+		if err == nil {
+			return nil
+		}
+		slog.WarnContext(ctx, "re-establishing watch failed", "path", dir, "attempt", attempt, "delay", backoff, logging.Err(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, rewatchMaxBackoff)
+	}
+}
+
+// Adds a watch on dir after making sure it is still a directory.
+func addDirWatch(watcher *fsnotify.Watcher, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", dir)
+	}
+	return watcher.Add(dir)
 }
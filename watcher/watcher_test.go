@@ -0,0 +1,245 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/mijolabs/vaultage/backup"
+	"github.com/mijolabs/vaultage/history"
+)
+
+func TestRunLoop_RecoversFromDataDirRecreation(t *testing.T) {
+	oldMin, oldMax := rewatchMinBackoff, rewatchMaxBackoff
+	t.Cleanup(func() { rewatchMinBackoff, rewatchMaxBackoff = oldMin, oldMax })
+	rewatchMinBackoff = 10 * time.Millisecond
+	rewatchMaxBackoff = 50 * time.Millisecond
+
+	dataDir := filepath.Join(t.TempDir(), "data")
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatalf("creating data dir: %v", err)
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("creating watcher: %v", err)
+	}
+	defer w.Close()
+	if err := w.Add(dataDir); err != nil {
+		t.Fatalf("adding watch: %v", err)
+	}
+
+	backups := make(chan history.Trigger, 10)
+	backupFn := func(trigger history.Trigger) error {
+		backups <- trigger
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	l := newLoop(w, dataDir, 10*time.Millisecond, backupFn)
+	go func() { done <- l.run(ctx) }()
+
+	// Simulate a volume re-mount by replacing the directory
+	if err := os.RemoveAll(dataDir); err != nil {
+		t.Fatalf("removing data dir: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatalf("recreating data dir: %v", err)
+	}
+
+	// Recovery schedules a safety backup on its own
+	if trigger := waitForBackup(t, backups); trigger != history.TriggerRecovery {
+		t.Fatalf("expected recovery backup, got %q", trigger)
+	}
+
+	// Changes in the recreated directory must be seen again
+	if err := os.WriteFile(filepath.Join(dataDir, WalFileName), []byte("wal"), 0644); err != nil {
+		t.Fatalf("writing WAL file: %v", err)
+	}
+	if trigger := waitForBackup(t, backups); trigger != history.TriggerWAL {
+		t.Fatalf("expected WAL backup, got %q", trigger)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRunLoop_RetriesFailedBackups(t *testing.T) {
+	dataDir := t.TempDir()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("creating watcher: %v", err)
+	}
+	defer w.Close()
+	if err := w.Add(dataDir); err != nil {
+		t.Fatalf("adding watch: %v", err)
+	}
+
+	// Fail twice, then succeed
+	attempts := 0
+	backups := make(chan history.Trigger, 10)
+	backupFn := func(trigger history.Trigger) error {
+		attempts++
+		backups <- trigger
+		if attempts < 3 {
+			return errors.New("disk full")
+		}
+		return nil
+	}
+
+	statuses := make(chan Status, 100)
+	l := newLoop(w, dataDir, 10*time.Millisecond, backupFn)
+	l.retry = RetryConfig{Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	l.onStatus = func(s Status) { statuses <- s }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- l.run(ctx) }()
+
+	if err := os.WriteFile(filepath.Join(dataDir, WalFileName), []byte("wal"), 0644); err != nil {
+		t.Fatalf("writing WAL file: %v", err)
+	}
+
+	for _, want := range []history.Trigger{history.TriggerWAL, history.TriggerRetry, history.TriggerRetry} {
+		if trigger := waitForBackup(t, backups); trigger != want {
+			t.Fatalf("expected %s backup, got %q", want, trigger)
+		}
+	}
+
+	// Wait for the status published after the successful run
+	var last Status
+	deadline := time.After(5 * time.Second)
+	for last.LastSuccess.IsZero() {
+		select {
+		case last = <-statuses:
+		case <-deadline:
+			t.Fatal("timed out waiting for success status")
+		}
+	}
+
+	cancel()
+	<-done
+
+	if last.Unbacked || last.RetryAttempt != 0 || last.LastError != "" {
+		t.Fatalf("expected clean status after success, got %+v", last)
+	}
+}
+
+func TestRetryConfig_Delay(t *testing.T) {
+	r := RetryConfig{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := r.delay(i + 1); got != w {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, w, got)
+		}
+	}
+
+	// Without a maximum the delay stops doubling at the ceiling instead
+	// of overflowing
+	r = RetryConfig{Backoff: 30 * time.Second}
+	for _, attempt := range []int{30, 64, 1000} {
+		if got := r.delay(attempt); got != retryBackoffCeiling {
+			t.Errorf("attempt %d without maximum: expected %s, got %s", attempt, retryBackoffCeiling, got)
+		}
+	}
+}
+
+func waitForBackup(t *testing.T, backups <-chan history.Trigger) history.Trigger {
+	t.Helper()
+
+	select {
+	case trigger := <-backups:
+		return trigger
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for backup")
+		return ""
+	}
+}
+
+func TestStartupBackupReason(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	db, err := sql.Open("sqlite", filepath.Join(dataDir, "db.sqlite3"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		PRAGMA journal_mode=WAL;
+		CREATE TABLE items (id INTEGER PRIMARY KEY, value TEXT);
+		INSERT INTO items (value) VALUES ('one');
+	`); err != nil {
+		t.Fatalf("creating test data: %v", err)
+	}
+
+	cfg := Config{BackupOnStart: true, BackupOnStartMaxAge: time.Hour, BackupOnStartIfChanged: true}
+	cfg.DataDir = dataDir
+	cfg.OutputDir = outputDir
+	cfg.History = history.Open(t.TempDir())
+
+	if reason, err := startupBackupReason(ctx, cfg); err != nil || reason == "" {
+		t.Fatalf("expected backup without existing backups, got %q, %v", reason, err)
+	}
+
+	// A fresh backup, recorded without a database digest by an earlier version
+	backupPath := filepath.Join(outputDir, "vaultage-20260101_000000.tar.age")
+	if err := os.WriteFile(backupPath, []byte("backup"), 0644); err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+	record := history.Record{RunID: "1", Started: time.Now(), Finished: time.Now(), Result: history.ResultSuccess, Archive: "vaultage-20260101_000000.tar.age"}
+	if err := cfg.History.Append(record); err != nil {
+		t.Fatalf("appending history: %v", err)
+	}
+	if reason, err := startupBackupReason(ctx, cfg); err != nil || !strings.Contains(reason, "no database digest") {
+		t.Fatalf("expected backup without a recorded digest, got %q, %v", reason, err)
+	}
+
+	// The last successful backup holds the current data
+	record.RunID = "2"
+	if record.DatabaseSHA256, err = backup.DatabaseDigest(ctx, cfg.Config); err != nil {
+		t.Fatalf("DatabaseDigest: %v", err)
+	}
+	if err := cfg.History.Append(record); err != nil {
+		t.Fatalf("appending history: %v", err)
+	}
+	if reason, err := startupBackupReason(ctx, cfg); err != nil || reason != "" {
+		t.Fatalf("expected no backup, got %q, %v", reason, err)
+	}
+
+	// Writes that leave the data as it was, such as on a restart, do not count
+	if _, err := db.Exec(`UPDATE items SET value = 'one'`); err != nil {
+		t.Fatalf("updating test data: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dataDir, WalFileName), future, future); err != nil {
+		t.Fatalf("setting WAL mtime: %v", err)
+	}
+	if reason, err := startupBackupReason(ctx, cfg); err != nil || reason != "" {
+		t.Fatalf("expected no backup after touching the database, got %q, %v", reason, err)
+	}
+
+	// The data changes after the last backup
+	if _, err := db.Exec(`INSERT INTO items (value) VALUES ('two')`); err != nil {
+		t.Fatalf("updating test data: %v", err)
+	}
+	if reason, err := startupBackupReason(ctx, cfg); err != nil || !strings.Contains(reason, "database changed") {
+		t.Fatalf("expected backup after database change, got %q, %v", reason, err)
+	}
+}